@@ -0,0 +1,137 @@
+package asnprefix
+
+import (
+	"log/slog"
+	"net/netip"
+	"sort"
+)
+
+// Сведение префиксов к минимальному набору CIDR: вложенные префиксы
+// поглощаются охватывающими, а соседние половины объединяются в общий
+// родительский префикс. IPv4 и IPv6 сводятся независимо, результат
+// отсортирован по адресу (сначала IPv4).
+func aggregatePrefixes(prefixes []netip.Prefix) []netip.Prefix {
+	sorted := make([]netip.Prefix, 0, len(prefixes))
+	for _, p := range prefixes {
+		sorted = append(sorted, p.Masked())
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if c := sorted[i].Addr().Compare(sorted[j].Addr()); c != 0 {
+			return c < 0
+		}
+		return sorted[i].Bits() < sorted[j].Bits()
+	})
+
+	var stack []netip.Prefix
+	for _, p := range sorted {
+		// Охватывающий префикс при такой сортировке всегда идёт раньше вложенного
+		if n := len(stack); n > 0 && stack[n-1].Overlaps(p) && stack[n-1].Bits() <= p.Bits() {
+			continue
+		}
+		stack = append(stack, p)
+
+		// Объединяем пары соседних половин, пока это возможно
+		for n := len(stack); n >= 2; n = len(stack) {
+			a, b := stack[n-2], stack[n-1]
+			if a.Bits() != b.Bits() || a.Bits() == 0 || a.Addr().Is4() != b.Addr().Is4() {
+				break
+			}
+			parentA, _ := a.Addr().Prefix(a.Bits() - 1)
+			parentB, _ := b.Addr().Prefix(b.Bits() - 1)
+			if parentA != parentB {
+				break
+			}
+			stack = append(stack[:n-2], parentA)
+		}
+	}
+	return stack
+}
+
+// Сведение записей к минимальному набору префиксов. Запись итогового
+// префикса получает все домены и IP-адреса исходных записей; номер AS
+// сохраняется, только если он у всех исходных записей совпадает.
+// Записи с некорректным префиксом пропускаются с предупреждением.
+func aggregateRecords(records []PrefixRecord) []PrefixRecord {
+	var prefixes []netip.Prefix
+	parsed := make([]netip.Prefix, len(records))
+	valid := make([]bool, len(records))
+	for i, r := range records {
+		p, err := r.CIDR()
+		if err != nil {
+			slog.Warn("Skipping invalid prefix during aggregation", "prefix", r.Prefix, "domain", r.Domain, "error", err)
+			continue
+		}
+		parsed[i], valid[i] = p, true
+		prefixes = append(prefixes, parsed[i])
+	}
+
+	aggregated := aggregatePrefixes(prefixes)
+	results := make([]PrefixRecord, len(aggregated))
+	used := make([]bool, len(aggregated))
+	for i, p := range aggregated {
+		results[i].Prefix = p.String()
+	}
+
+	for i, r := range records {
+		if !valid[i] {
+			continue
+		}
+		pos := containingPrefix(aggregated, parsed[i])
+		if pos < 0 {
+			continue
+		}
+
+		entry := &results[pos]
+		if !used[pos] {
+			used[pos] = true
+			entry.Domain, entry.IP, entry.ASN, entry.RecordType = r.Domain, r.IP, r.ASN, r.RecordType
+		} else {
+			if entry.ASN != r.ASN {
+				entry.ASN = 0
+			}
+			if entry.RecordType != r.RecordType {
+				entry.RecordType = ""
+			}
+		}
+
+		domains := r.Domains
+		if len(domains) == 0 {
+			domains = []string{r.Domain}
+		}
+		for _, d := range domains {
+			entry.Domains = appendUnique(entry.Domains, d)
+		}
+		entry.Tags = mergeTags(entry.Tags, r.Tags)
+		mergeIPs(entry, r)
+	}
+
+	// Список доменов нужен, только если их несколько
+	for i := range results {
+		if len(results[i].Domains) <= 1 {
+			results[i].Domains = nil
+		}
+	}
+	return results
+}
+
+// Индекс префикса из отсортированного набора aggregated, который содержит p, или -1
+func containingPrefix(aggregated []netip.Prefix, p netip.Prefix) int {
+	// Последний префикс с адресом не больше адреса p
+	i := sort.Search(len(aggregated), func(i int) bool {
+		return aggregated[i].Addr().Compare(p.Addr()) > 0
+	}) - 1
+	if i >= 0 && aggregated[i].Contains(p.Addr()) && aggregated[i].Bits() <= p.Bits() {
+		return i
+	}
+	return -1
+}
+
+// Добавление строки в список, если её там ещё нет
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
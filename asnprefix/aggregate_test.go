@@ -0,0 +1,68 @@
+package asnprefix
+
+import (
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Разбор списка префиксов через запятую для таблиц тестов
+func mustPrefixes(t *testing.T, list string) []netip.Prefix {
+	t.Helper()
+	var prefixes []netip.Prefix
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			prefixes = append(prefixes, netip.MustParsePrefix(s))
+		}
+	}
+	return prefixes
+}
+
+func TestAggregatePrefixes(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"adjacent halves", "10.0.0.128/25, 10.0.0.0/25", "10.0.0.0/24"},
+		{"cascade", "10.0.0.0/26, 10.0.0.64/26, 10.0.0.128/26, 10.0.0.192/26", "10.0.0.0/24"},
+		{"adjacent but not siblings", "10.0.1.0/24, 10.0.2.0/24", "10.0.1.0/24, 10.0.2.0/24"},
+		{"nested", "10.1.0.0/16, 10.0.0.0/8, 10.1.2.0/24", "10.0.0.0/8"},
+		{"duplicates", "192.0.2.0/24, 192.0.2.0/24", "192.0.2.0/24"},
+		{"host bits", "192.0.2.1/25, 192.0.2.130/25", "192.0.2.0/24"},
+		{"ipv6 adjacent", "2001:db8:8000::/33, 2001:db8::/33", "2001:db8::/32"},
+		{"ipv6 nested", "2001:db8::/32, 2001:db8:1::/48", "2001:db8::/32"},
+		// Семейства сводятся независимо, IPv4 идёт первым
+		{"mixed families", "2001:db8::/33, 10.0.0.0/25, 2001:db8:8000::/33, 10.0.0.128/25, 198.51.100.0/24",
+			"10.0.0.0/24, 198.51.100.0/24, 2001:db8::/32"},
+		{"ipv4-mapped stays ipv6", "::ffff:10.0.0.0/120, 10.0.1.0/24", "10.0.1.0/24, ::ffff:10.0.0.0/120"},
+		{"default routes", "0.0.0.0/1, 128.0.0.0/1", "0.0.0.0/0"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		got := aggregatePrefixes(mustPrefixes(t, tt.in))
+		if want := mustPrefixes(t, tt.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: aggregatePrefixes = %v, want %v", tt.name, got, want)
+		}
+	}
+}
+
+func TestAggregateRecords(t *testing.T) {
+	records := []PrefixRecord{
+		{Domain: "a.example", IP: "192.0.2.1", ASN: 64500, Prefix: "192.0.2.0/25", Tags: []string{"env:prod"}},
+		{Domain: "b.example", IP: "192.0.2.200", ASN: 64501, Prefix: "192.0.2.128/25"},
+		{Domain: "a.example", IP: "2001:db8::1", ASN: 64500, Prefix: "2001:db8::/32"},
+		{Domain: "a.example", IP: "2001:db8:1::1", ASN: 64500, Prefix: "2001:db8:1::/48"},
+		{Domain: "c.example", Prefix: "not-a-prefix"},
+	}
+	want := []PrefixRecord{
+		// Разные AS у исходных записей — номер AS не сохраняется
+		{Domain: "a.example", Domains: []string{"a.example", "b.example"}, IP: "192.0.2.1", IPs: []string{"192.0.2.1", "192.0.2.200"},
+			Prefix: "192.0.2.0/24", Tags: []string{"env:prod"}},
+		{Domain: "a.example", IP: "2001:db8::1", IPs: []string{"2001:db8::1", "2001:db8:1::1"}, ASN: 64500, Prefix: "2001:db8::/32"},
+	}
+	if got := aggregateRecords(records); !reflect.DeepEqual(got, want) {
+		t.Errorf("aggregateRecords =\n%+v\nwant\n%+v", got, want)
+	}
+}
@@ -0,0 +1,131 @@
+package asnprefix
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Запись существующего JSON-файла: текущий формат или старый (префикс в hostname)
+type existingRecord struct {
+	PrefixRecord
+	Hostname string `json:"hostname"`
+}
+
+// Загрузка записей из ранее сохранённого выходного файла для -append.
+// Отсутствующий файл считается пустым; записи о неудачных доменах и
+// некорректные префиксы пропускаются.
+func loadExistingRecords(filename, format string) ([]PrefixRecord, error) {
+	data, err := ioutil.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		slog.Info("Output file not found, nothing to append to", "path", filename)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read existing output file: %w", err)
+	}
+
+	var raw []existingRecord
+	switch format {
+	case FormatJSON:
+		// Массив записей (-flat, старый формат) или документ с разделом results
+		if err := json.Unmarshal(data, &raw); err != nil {
+			var doc struct {
+				Results []existingRecord `json:"results"`
+			}
+			if docErr := json.Unmarshal(data, &doc); docErr != nil || doc.Results == nil {
+				return nil, fmt.Errorf("failed to parse existing output file %s: %w", filename, err)
+			}
+			raw = doc.Results
+		}
+	case FormatNDJSON:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		for dec.More() {
+			var r existingRecord
+			if err := dec.Decode(&r); err != nil {
+				return nil, fmt.Errorf("failed to parse existing output file %s: %w", filename, err)
+			}
+			raw = append(raw, r)
+		}
+	case FormatPlain:
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+				raw = append(raw, existingRecord{PrefixRecord: PrefixRecord{Prefix: line}})
+			}
+		}
+	default:
+		return nil, fmt.Errorf("-append is not supported for %s output", format)
+	}
+
+	records := make([]PrefixRecord, 0, len(raw))
+	for _, r := range raw {
+		if r.Prefix == "" {
+			r.Prefix = r.Hostname // Старый формат: префикс в поле hostname
+		}
+		if r.Prefix == "" {
+			continue // Запись о неудачном домене
+		}
+		p, err := r.CIDR()
+		if err != nil {
+			slog.Warn("Skipping invalid prefix in existing output", "path", filename, "prefix", r.Prefix)
+			continue
+		}
+		r.Prefix = p.String()
+		records = append(records, r.PrefixRecord)
+	}
+	return records, nil
+}
+
+// Объединение существующих записей с новыми без повторов префиксов: порядок
+// существующих сохраняется, новые префиксы добавляются в конец, а домены
+// совпавших префиксов дописываются к имеющейся записи
+func mergeRecords(existing, fresh []PrefixRecord) []PrefixRecord {
+	merged := make([]PrefixRecord, 0, len(existing)+len(fresh))
+	index := make(map[string]int, len(existing)+len(fresh))
+	add := func(r PrefixRecord) {
+		key := r.Prefix
+		if p, err := r.CIDR(); err == nil {
+			key = p.String()
+		}
+		pos, ok := index[key]
+		if !ok {
+			index[key] = len(merged)
+			merged = append(merged, r)
+			return
+		}
+
+		entry := &merged[pos]
+		domains := r.Domains
+		if len(domains) == 0 && r.Domain != "" {
+			domains = []string{r.Domain}
+		}
+		union := entry.Domains
+		if len(union) == 0 && entry.Domain != "" {
+			union = []string{entry.Domain}
+		}
+		for _, d := range domains {
+			union = appendUnique(union, d)
+		}
+		entry.Tags = mergeTags(entry.Tags, r.Tags)
+		mergeIPs(entry, r)
+		// Список domains заводится, только если доменов стало больше одного
+		if len(entry.Domains) > 0 || len(union) > 1 {
+			entry.Domains = union
+		}
+	}
+
+	for _, r := range existing {
+		add(r)
+	}
+	for _, r := range fresh {
+		add(r)
+	}
+	return merged
+}
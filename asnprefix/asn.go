@@ -0,0 +1,84 @@
+package asnprefix
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseASN разбирает номер AS в виде "64500" или "AS64500"
+func ParseASN(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	digits := s
+	if len(digits) > 2 && strings.EqualFold(digits[:2], "AS") {
+		digits = digits[2:]
+	}
+
+	asNumber, err := strconv.ParseUint(digits, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid AS number: %q", s)
+	}
+	return int(asNumber), nil
+}
+
+// ParseASNList разбирает список номеров AS через запятую; пустые элементы пропускаются
+func ParseASNList(s string) ([]int, error) {
+	var asNumbers []int
+	for _, item := range strings.Split(s, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		asNumber, err := ParseASN(item)
+		if err != nil {
+			return nil, err
+		}
+		asNumbers = append(asNumbers, asNumber)
+	}
+	return asNumbers, nil
+}
+
+// Диапазоны номеров AS, не используемых в глобальной маршрутизации:
+// частные (RFC 6996), документационные (RFC 5398), AS_TRANS (RFC 6793)
+// и зарезервированные IANA
+var reservedASNRanges = [][2]int{
+	{23456, 23456},
+	{64496, 64511},
+	{64512, 65534},
+	{65535, 65535},
+	{65536, 65551},
+	{65552, 131071},
+	{4200000000, 4294967294},
+	{4294967295, 4294967295},
+}
+
+// Является ли номер публичным номером AS; AS0 (RFC 7607) публичным не считается
+func isPublicASN(asNumber int) bool {
+	if asNumber <= 0 {
+		return false
+	}
+	for _, r := range reservedASNRanges {
+		if asNumber >= r[0] && asNumber <= r[1] {
+			return false
+		}
+	}
+	return true
+}
+
+// Разрешено ли запрашивать префиксы AS: AS не в списке запрета и,
+// если список разрешённых задан, входит в него
+func asnAllowed(cfg Config, asNumber int) bool {
+	for _, denied := range cfg.DenyASNs {
+		if denied == asNumber {
+			return false
+		}
+	}
+	if len(cfg.AllowASNs) == 0 {
+		return true
+	}
+	for _, allowed := range cfg.AllowASNs {
+		if allowed == asNumber {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package asnprefix
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Получение названий AS одним bulk-запросом к Team Cymru.
+// AS, для которых название не найдено, в ответ не попадают.
+func getASNamesBulk(ctx context.Context, asNumbers []int) (map[int]string, error) {
+	var query strings.Builder
+	query.WriteString("begin\r\nnoheader")
+	for _, asNumber := range asNumbers {
+		query.WriteString("\r\nAS" + strconv.Itoa(asNumber))
+	}
+	query.WriteString("\r\nend")
+
+	response, err := queryWhoisServer(ctx, cymruServer, query.String())
+	if err != nil {
+		return nil, err
+	}
+
+	// Строки ответа имеют вид "15169   | GOOGLE, US"; название — последняя колонка
+	names := make(map[int]string)
+	for _, row := range parseCymruTable(response) {
+		if row.ASName != "" {
+			names[row.ASNs[0]] = row.ASName
+		}
+	}
+
+	if len(names) == 0 && len(asNumbers) > 0 {
+		return nil, fmt.Errorf("no AS names in bulk whois response")
+	}
+	return names, nil
+}
+
+// Добавление названий AS в записи: из кэша, остальные одним запросом к Team Cymru.
+// Ошибка запроса не прерывает работу, записи остаются без названий.
+func enrichASNames(ctx context.Context, records []PrefixRecord, cfg Config, cache *lookupCache) {
+	names := make(map[int]string)
+	var missing []int
+	for _, r := range records {
+		if r.ASN == 0 {
+			continue // У сведённого префикса из разных AS номера нет
+		}
+		if _, ok := names[r.ASN]; ok {
+			continue
+		}
+		name, ok := cache.asName(r.ASN)
+		names[r.ASN] = name
+		if !ok {
+			missing = append(missing, r.ASN)
+		}
+	}
+
+	if len(missing) > 0 {
+		err := cfg.limiter.wait(ctx)
+		if err == nil {
+			namesCtx, cancel := withTimeout(ctx, cfg.WhoisTimeout)
+			start := time.Now()
+			var fetched map[int]string
+			if cfg.Fixtures != "" {
+				fetched, err = fixtureDir(cfg.Fixtures).asNames(namesCtx, missing)
+			} else {
+				fetched, err = getASNamesBulk(namesCtx, missing)
+			}
+			cfg.latency.observe(sourceWhois, start)
+			cancel()
+			for asNumber, name := range fetched {
+				names[asNumber] = name
+				cache.storeASName(asNumber, name)
+			}
+		}
+		if err != nil {
+			slog.Warn("Error getting AS names", "error", err)
+		}
+	}
+
+	for i := range records {
+		records[i].ASName = names[records[i].ASN]
+	}
+}
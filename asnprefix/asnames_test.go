@@ -0,0 +1,155 @@
+package asnprefix
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Фейковый сервер Team Cymru: принимает bulk-запрос до строки "end" и отвечает
+// response; cymruServer на время теста указывает на него. Возвращает функцию
+// со списком полученных запросов.
+func fakeCymru(t *testing.T, response string) func() []string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var queries []string
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var query strings.Builder
+			r := bufio.NewReader(conn)
+			for {
+				line, err := r.ReadString('\n')
+				query.WriteString(line)
+				if err != nil || strings.TrimSpace(line) == "end" {
+					break
+				}
+			}
+			mu.Lock()
+			queries = append(queries, query.String())
+			mu.Unlock()
+			io.WriteString(conn, response)
+			conn.Close()
+		}
+	}()
+
+	saved := cymruServer
+	cymruServer = ln.Addr().String()
+	t.Cleanup(func() {
+		cymruServer = saved
+		ln.Close()
+	})
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), queries...)
+	}
+}
+
+func TestGetASNamesBulk(t *testing.T) {
+	queries := fakeCymru(t, "15169   | GOOGLE, US\n15133   | EDGECAST, US\n")
+
+	names, err := getASNamesBulk(context.Background(), []int{15169, 15133, 64496})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[int]string{15169: "GOOGLE, US", 15133: "EDGECAST, US"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("getASNamesBulk = %v, want %v", names, want)
+	}
+	if got, want := queries(), []string{"begin\r\nnoheader\r\nAS15169\r\nAS15133\r\nAS64496\r\nend\r\n"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("queries = %q, want %q", got, want)
+	}
+}
+
+func TestGetASNamesBulkEmptyResponse(t *testing.T) {
+	fakeCymru(t, "")
+	if _, err := getASNamesBulk(context.Background(), []int{15169}); err == nil {
+		t.Error("getASNamesBulk with an empty response succeeded")
+	}
+}
+
+func TestEnrichASNames(t *testing.T) {
+	queries := fakeCymru(t, "15169   | GOOGLE, US\n")
+	cache, err := loadLookupCache(filepath.Join(t.TempDir(), cacheFileName), Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.storeASName(15133, "EDGECAST, US")
+
+	records := []PrefixRecord{
+		{Domain: "google.com", ASN: 15169, Prefix: "142.250.0.0/15"},
+		{Domain: "example.com", ASN: 15133, Prefix: "93.184.215.0/24"},
+		{Domain: "google.com", ASN: 15169, Prefix: "2a00:1450::/32"},
+		// Сведённый префикс из разных AS
+		{Domain: "example.com", Prefix: "93.184.214.0/23"},
+	}
+	enrichASNames(context.Background(), records, Config{}, cache)
+
+	want := []string{"GOOGLE, US", "EDGECAST, US", "GOOGLE, US", ""}
+	for i, r := range records {
+		if r.ASName != want[i] {
+			t.Errorf("record %d ASName = %q, want %q", i, r.ASName, want[i])
+		}
+	}
+	// Название из кэша не запрашивается, AS15169 запрошена один раз
+	if got, want := queries(), []string{"begin\r\nnoheader\r\nAS15169\r\nend\r\n"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("queries = %q, want %q", got, want)
+	}
+	if name, ok := cache.asName(15169); !ok || name != "GOOGLE, US" {
+		t.Errorf("cached name = %q, %t; want GOOGLE, US", name, ok)
+	}
+}
+
+func TestEnrichASNamesError(t *testing.T) {
+	fakeCymru(t, "")
+	records := []PrefixRecord{{Domain: "google.com", ASN: 15169, Prefix: "142.250.0.0/15"}}
+
+	logs := captureLog(t)
+	enrichASNames(context.Background(), records, Config{}, nil)
+	if records[0].ASName != "" {
+		t.Errorf("ASName = %q after a failed request, want empty", records[0].ASName)
+	}
+	if !strings.Contains(logs.String(), "Error getting AS names") {
+		t.Errorf("no warning in log:\n%s", logs.String())
+	}
+}
+
+func TestEnrichASNamesFixtures(t *testing.T) {
+	dir := writeFixtures(t, map[string]string{"whois/AS15169": "15169   | GOOGLE, US\n"})
+	records := []PrefixRecord{{Domain: "google.com", ASN: 15169, Prefix: "142.250.0.0/15"}}
+	enrichASNames(context.Background(), records, Config{Fixtures: dir}, nil)
+	if records[0].ASName != "GOOGLE, US" {
+		t.Errorf("ASName = %q, want GOOGLE, US", records[0].ASName)
+	}
+}
+
+func TestGetASNumbersBulk(t *testing.T) {
+	queries := fakeCymru(t, "15169   | 8.8.8.8          | GOOGLE, US\n15133   | 93.184.215.14    | EDGECAST, US\n")
+
+	asNumbers, names, err := getASNumbersBulk(context.Background(), []string{"8.8.8.8", "93.184.215.14"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string][]int{"8.8.8.8": {15169}, "93.184.215.14": {15133}}; !reflect.DeepEqual(asNumbers, want) {
+		t.Errorf("AS numbers = %v, want %v", asNumbers, want)
+	}
+	if want := map[int]string{15169: "GOOGLE, US", 15133: "EDGECAST, US"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("names = %v, want %v", names, want)
+	}
+	if got, want := queries(), []string{"begin\r\nnoheader\r\n8.8.8.8\r\n93.184.215.14\r\nend\r\n"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("queries = %q, want %q", got, want)
+	}
+}
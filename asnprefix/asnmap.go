@@ -0,0 +1,172 @@
+package asnprefix
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/netip"
+	"path/filepath"
+	"strings"
+)
+
+// Заранее известные соответствия адресов и AS из файла -asn-map: для адреса из
+// карты whois не запрашивается. Ключ — IP-адрес или CIDR; при нескольких
+// подходящих диапазонах берётся самый узкий. nil-карта ничего не содержит.
+type asnMap struct {
+	prefixes []netip.Prefix
+	asns     [][]int
+}
+
+// Загрузка карты из JSON или CSV; формат определяется по расширению,
+// а без него — по первому символу файла.
+//
+// JSON — объект {"адрес или CIDR": номер AS или список номеров}:
+//
+//	{"93.184.215.14": 15133, "2606:2800::/32": ["AS15133"]}
+//
+// CSV — строки "адрес или CIDR,номер AS" (несколько номеров через ";");
+// строка заголовка и строки-комментарии (#) пропускаются.
+func loadASNMap(filename string) (*asnMap, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ASN map: %w", err)
+	}
+
+	entries := make(map[string][]int)
+	switch ext := strings.ToLower(filepath.Ext(filename)); {
+	case ext == ".json", ext != ".csv" && bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")):
+		err = parseASNMapJSON(data, entries)
+	default:
+		err = parseASNMapCSV(data, entries)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ASN map %s: %w", filename, err)
+	}
+
+	m := &asnMap{}
+	for key, asns := range entries {
+		prefix, err := parseMapKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse ASN map %s: %w", filename, err)
+		}
+		m.prefixes = append(m.prefixes, prefix)
+		m.asns = append(m.asns, asns)
+	}
+	return m, nil
+}
+
+// Ключ карты: одиночный адрес становится префиксом /32 или /128
+func parseMapKey(key string) (netip.Prefix, error) {
+	key = strings.TrimSpace(key)
+	if strings.Contains(key, "/") {
+		prefix, err := netip.ParsePrefix(key)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR %q: %w", key, err)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(key)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP %q: %w", key, err)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func parseASNMapJSON(data []byte, entries map[string][]int) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	for key, value := range raw {
+		// Значение — номер или строка "AS64500" либо список из них
+		var items []json.RawMessage
+		if err := json.Unmarshal(value, &items); err != nil {
+			items = []json.RawMessage{value}
+		}
+		for _, item := range items {
+			asNumber, err := ParseASN(strings.Trim(string(item), `"`))
+			if err != nil {
+				return fmt.Errorf("entry %q: %w", key, err)
+			}
+			entries[key] = appendUniqueInt(entries[key], asNumber)
+		}
+	}
+	return nil
+}
+
+func parseASNMapCSV(data []byte, entries map[string][]int) error {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return err
+	}
+
+	for i, record := range records {
+		if len(record) < 2 {
+			return fmt.Errorf("line %d: expected \"ip,asn\"", i+1)
+		}
+		key := strings.TrimSpace(record[0])
+		var asns []int
+		for _, item := range strings.Split(record[1], ";") {
+			asNumber, err := ParseASN(item)
+			if err != nil {
+				// Первая строка с нечисловым номером — заголовок
+				if i == 0 {
+					break
+				}
+				return fmt.Errorf("line %d: %w", i+1, err)
+			}
+			asns = append(asns, asNumber)
+		}
+		for _, asNumber := range asns {
+			entries[key] = appendUniqueInt(entries[key], asNumber)
+		}
+	}
+	return nil
+}
+
+// Номера AS адреса по самому узкому подходящему диапазону карты
+func (m *asnMap) lookup(ip string) ([]int, bool) {
+	if m == nil {
+		return nil, false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, false
+	}
+	addr = addr.Unmap()
+
+	best := -1
+	for i, prefix := range m.prefixes {
+		if prefix.Contains(addr) && (best < 0 || prefix.Bits() > m.prefixes[best].Bits()) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return nil, false
+	}
+	return m.asns[best], true
+}
+
+// Число записей карты
+func (m *asnMap) len() int {
+	if m == nil {
+		return 0
+	}
+	return len(m.prefixes)
+}
+
+// Добавление номера в список, если его там ещё нет
+func appendUniqueInt(list []int, n int) []int {
+	if containsInt(list, n) {
+		return list
+	}
+	return append(list, n)
+}
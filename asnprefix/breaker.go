@@ -0,0 +1,99 @@
+package asnprefix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Значения по умолчанию для флагов -breaker-threshold и -breaker-cooldown
+const (
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = 30 * time.Second
+)
+
+// Автомат защиты API префиксов, общий для всех воркеров. После threshold
+// сбоев подряд цепь размыкается: запросы на время cooldown сразу завершаются
+// ошибкой ErrCircuitOpen. По истечении паузы пропускается один пробный запрос;
+// его успех замыкает цепь, сбой снова размыкает её. nil-автомат ничего не ограничивает.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int       // Сбоев подряд
+	openUntil time.Time // Конец паузы; нулевое значение — цепь замкнута
+	probing   bool      // Пробный запрос после паузы ещё выполняется
+}
+
+// Автомат с порогом threshold; при threshold <= 0 возвращается nil
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Разрешение на запрос: ошибка, если цепь разомкнута или пробный запрос уже идёт
+func (b *circuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openUntil.IsZero() {
+		return nil
+	}
+	if remaining := time.Until(b.openUntil); remaining > 0 {
+		return fmt.Errorf("%w: retrying in %s", ErrCircuitOpen, remaining.Round(time.Second))
+	}
+	if b.probing {
+		return fmt.Errorf("%w: waiting for the probe request", ErrCircuitOpen)
+	}
+	b.probing = true
+	slog.Info("Prefix API circuit breaker half-open, sending a probe request")
+	return nil
+}
+
+// Учёт результата запроса: сбой API увеличивает счётчик, любой ответ сервера
+// (в том числе 404) его сбрасывает. Запрос, прерванный отменой или дедлайном
+// ctx вызывающего, не учитывается, но освобождает место пробного запроса.
+func (b *circuitBreaker) record(ctx context.Context, err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ctx.Err() != nil {
+		b.probing = false
+		return
+	}
+
+	if !isAPIFailure(err) {
+		if !b.openUntil.IsZero() {
+			slog.Info("Prefix API circuit breaker closed")
+		}
+		b.failures, b.openUntil, b.probing = 0, time.Time{}, false
+		return
+	}
+
+	b.failures++
+	if b.probing || b.failures == b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+		b.probing = false
+		slog.Warn("Prefix API circuit breaker open, failing prefix requests fast", "failures", b.failures, "cooldown", b.cooldown, "error", err)
+	}
+}
+
+// Сбой, говорящий о недоступности API: сетевые ошибки, 5xx и 429, а также
+// непригодный ответ (например, страница ошибки вместо JSON)
+func isAPIFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	return isRetryable(err) || errors.Is(err, ErrUnexpectedResponse)
+}
@@ -0,0 +1,288 @@
+package asnprefix
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Имя файла кэша в директории кэша
+const cacheFileName = "lookup-cache.json"
+
+// Номера AS для IP-адреса с временем получения
+type cachedASNumbers struct {
+	ASNumbers []int     `json:"asns"`
+	Fetched   time.Time `json:"fetched"`
+}
+
+// Префиксы AS с временем получения
+type cachedPrefixes struct {
+	Prefixes []Prefix  `json:"prefixes"`
+	Fetched  time.Time `json:"fetched"`
+}
+
+// Название AS с временем получения
+type cachedASName struct {
+	Name    string    `json:"name"`
+	Fetched time.Time `json:"fetched"`
+}
+
+// Полная цепочка успешно обработанного домена: IP-адреса, AS и итоговые записи
+type cachedDomain struct {
+	IPs     []string       `json:"ips"`
+	ASNs    []int          `json:"asns"`
+	Records []PrefixRecord `json:"records"`
+	Fetched time.Time      `json:"fetched"`
+}
+
+// Кэш результатов whois (IP -> AS), запросов префиксов (AS -> префиксы), названий AS
+// и результатов доменов целиком, сохраняемый в JSON-файл между запусками. Методы
+// безопасны для вызова из нескольких воркеров; на nil-кэше они ничего не делают.
+type lookupCache struct {
+	mu        sync.Mutex
+	path      string
+	ttl       time.Duration
+	domainTTL time.Duration
+	refresh   bool // Не читать записи из кэша, только обновлять их
+	dirty     bool
+
+	IPs      map[string]cachedASNumbers `json:"ips"`
+	Prefixes map[string]cachedPrefixes  `json:"prefixes"`
+	Names    map[string]cachedASName    `json:"names,omitempty"`
+	Domains  map[string]cachedDomain    `json:"domains,omitempty"`
+}
+
+// Загрузка кэша из файла; отсутствующий файл означает пустой кэш.
+// При ошибке чтения возвращается пустой кэш вместе с ошибкой.
+func loadLookupCache(path string, cfg Config) (*lookupCache, error) {
+	cache := &lookupCache{
+		path:      path,
+		ttl:       cfg.CacheTTL,
+		domainTTL: cfg.DomainCacheTTL,
+		refresh:   cfg.Refresh,
+		IPs:       make(map[string]cachedASNumbers),
+		Prefixes:  make(map[string]cachedPrefixes),
+		Names:     make(map[string]cachedASName),
+		Domains:   make(map[string]cachedDomain),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cache, nil
+	}
+	if err != nil {
+		return cache, fmt.Errorf("failed to read cache file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, cache); err != nil {
+		cache.IPs = make(map[string]cachedASNumbers)
+		cache.Prefixes = make(map[string]cachedPrefixes)
+		cache.Names = make(map[string]cachedASName)
+		cache.Domains = make(map[string]cachedDomain)
+		return cache, fmt.Errorf("failed to parse cache file: %w", err)
+	}
+	if cache.IPs == nil {
+		cache.IPs = make(map[string]cachedASNumbers)
+	}
+	if cache.Prefixes == nil {
+		cache.Prefixes = make(map[string]cachedPrefixes)
+	}
+	if cache.Names == nil {
+		cache.Names = make(map[string]cachedASName)
+	}
+	if cache.Domains == nil {
+		cache.Domains = make(map[string]cachedDomain)
+	}
+	return cache, nil
+}
+
+// Актуальна ли запись, полученная в момент fetched
+func (c *lookupCache) fresh(fetched time.Time) bool {
+	return c.ttl <= 0 || time.Since(fetched) < c.ttl
+}
+
+// Актуален ли результат домена, полученный в момент fetched
+func (c *lookupCache) domainFresh(fetched time.Time) bool {
+	return c.domainTTL <= 0 || time.Since(fetched) < c.domainTTL
+}
+
+// Ключ префиксов в кэше: источник и номер AS
+func prefixCacheKey(source string, asNumber int) string {
+	return fmt.Sprintf("%s/AS%d", source, asNumber)
+}
+
+// Ключ номеров AS в кэше: адрес, а с заданными -whois-server или -asn-regex —
+// ещё и они, так как от них зависит разбор ответа whois
+func asnCacheKey(ip string, cfg Config) string {
+	if len(cfg.WhoisServers) == 0 && cfg.ASNRegex == "" {
+		return ip
+	}
+	return fmt.Sprintf("%s|%s|%s", ip, strings.Join(cfg.WhoisServers, ","), cfg.ASNRegex)
+}
+
+// Номера AS по ключу asnCacheKey, если они есть в кэше и не устарели
+func (c *lookupCache) asNumbers(key string) ([]int, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.IPs[key]
+	if !ok || c.refresh || !c.fresh(entry.Fetched) {
+		return nil, false
+	}
+	return entry.ASNumbers, true
+}
+
+// Сохранение номеров AS по ключу asnCacheKey
+func (c *lookupCache) storeASNumbers(key string, asNumbers []int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.IPs[key] = cachedASNumbers{ASNumbers: asNumbers, Fetched: time.Now()}
+	c.dirty = true
+}
+
+// Префиксы AS из указанного источника, если они есть в кэше и не устарели
+func (c *lookupCache) prefixes(source string, asNumber int) ([]Prefix, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.Prefixes[prefixCacheKey(source, asNumber)]
+	if !ok || c.refresh || !c.fresh(entry.Fetched) {
+		return nil, false
+	}
+	return entry.Prefixes, true
+}
+
+// Сохранение префиксов AS из указанного источника
+func (c *lookupCache) storePrefixes(source string, asNumber int, prefixes []Prefix) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Prefixes[prefixCacheKey(source, asNumber)] = cachedPrefixes{Prefixes: prefixes, Fetched: time.Now()}
+	c.dirty = true
+}
+
+// Название AS, если оно есть в кэше и не устарело
+func (c *lookupCache) asName(asNumber int) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.Names[fmt.Sprintf("AS%d", asNumber)]
+	if !ok || c.refresh || !c.fresh(entry.Fetched) {
+		return "", false
+	}
+	return entry.Name, true
+}
+
+// Сохранение названия AS
+func (c *lookupCache) storeASName(asNumber int, name string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Names[fmt.Sprintf("AS%d", asNumber)] = cachedASName{Name: name, Fetched: time.Now()}
+	c.dirty = true
+}
+
+// Ключ результата домена: кроме имени учитываются настройки, от которых
+// зависят адреса, AS и префиксы в цепочке
+func domainCacheKey(domain string, cfg Config) string {
+	return fmt.Sprintf("%s|%s|%s%s|%s|whois=%s/%s|re=%s|all=%t|private=%t|privasn=%t|mx=%t|ns=%t|allow=%v|deny=%v|max=%d/%s|map=%s", domain, cfg.Resolver, cfg.DNSServer, dohURL(cfg), cfg.PrefixSource,
+		cfg.Whois, strings.Join(cfg.WhoisServers, ","), cfg.ASNRegex, cfg.ResolveAllIPs, cfg.IncludePrivate, cfg.AllowPrivateASN, cfg.IncludeMX, cfg.IncludeNS,
+		cfg.AllowASNs, cfg.DenyASNs, cfg.MaxPrefixes, cfg.MaxPrefixesAction, cfg.ASNMap)
+}
+
+// Результат домена, если он есть в кэше и не устарел
+func (c *lookupCache) domainResult(key string) (cachedDomain, bool) {
+	if c == nil {
+		return cachedDomain{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.Domains[key]
+	if !ok || c.refresh || !c.domainFresh(entry.Fetched) {
+		return cachedDomain{}, false
+	}
+	return entry, true
+}
+
+// Сохранение результата домена
+func (c *lookupCache) storeDomainResult(key string, entry cachedDomain) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry.Fetched = time.Now()
+	c.Domains[key] = entry
+	c.dirty = true
+}
+
+// Запись кэша в файл, если он изменился; устаревшие записи при этом удаляются
+func (c *lookupCache) save() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+
+	for ip, entry := range c.IPs {
+		if !c.fresh(entry.Fetched) {
+			delete(c.IPs, ip)
+		}
+	}
+	for key, entry := range c.Prefixes {
+		if !c.fresh(entry.Fetched) {
+			delete(c.Prefixes, key)
+		}
+	}
+	for key, entry := range c.Names {
+		if !c.fresh(entry.Fetched) {
+			delete(c.Names, key)
+		}
+	}
+	for key, entry := range c.Domains {
+		if !c.domainFresh(entry.Fetched) {
+			delete(c.Domains, key)
+		}
+	}
+
+	data, err := json.MarshalIndent(c, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache: %w", err)
+	}
+	if err := writeFileAtomic(c.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+
+	c.dirty = false
+	return nil
+}
@@ -0,0 +1,56 @@
+package asnprefix
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDomainCacheKeySettings(t *testing.T) {
+	base := Config{Resolver: ResolverDig, Whois: WhoisCommand, PrefixSource: PrefixSourceHE}
+	key := domainCacheKey("example.com", base)
+
+	changes := map[string]func(*Config){
+		"resolver":      func(c *Config) { c.Resolver = ResolverNative },
+		"whois":         func(c *Config) { c.Whois = WhoisBulk },
+		"whois servers": func(c *Config) { c.WhoisServers = []string{"whois.ripe.net"} },
+		"asn regex":     func(c *Config) { c.ASNRegex = `origin:\s*AS(\d+)` },
+		"prefix source": func(c *Config) { c.PrefixSource = PrefixSourceRIPEstat },
+		"include mx":    func(c *Config) { c.IncludeMX = true },
+	}
+	for name, change := range changes {
+		cfg := base
+		change(&cfg)
+		if domainCacheKey("example.com", cfg) == key {
+			t.Errorf("domain cache key does not depend on %s", name)
+		}
+	}
+	if domainCacheKey("example.org", base) == key {
+		t.Error("domain cache key does not depend on the domain")
+	}
+}
+
+func TestASNCacheKeySettings(t *testing.T) {
+	cache, err := loadLookupCache(filepath.Join(t.TempDir(), cacheFileName), Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defaults := Config{}
+	custom := Config{ASNRegex: `origin:\s*AS(\d+)`}
+	if got := asnCacheKey("192.0.2.1", defaults); got != "192.0.2.1" {
+		t.Errorf("default ASN cache key = %q, want the bare address", got)
+	}
+
+	cache.storeASNumbers(asnCacheKey("192.0.2.1", defaults), []int{64500})
+	if _, ok := cache.asNumbers(asnCacheKey("192.0.2.1", custom)); ok {
+		t.Error("AS numbers parsed with the default settings served for -asn-regex")
+	}
+	if _, ok := cache.asNumbers(asnCacheKey("192.0.2.1", Config{WhoisServers: []string{"whois.arin.net"}})); ok {
+		t.Error("AS numbers parsed with the default settings served for -whois-server")
+	}
+	got, ok := cache.asNumbers(asnCacheKey("192.0.2.1", defaults))
+	if !ok || !reflect.DeepEqual(got, []int{64500}) {
+		t.Errorf("cache.asNumbers = %v, %t; want [64500], true", got, ok)
+	}
+}
@@ -0,0 +1,159 @@
+package asnprefix
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Интервал записи контрольной точки по умолчанию
+const DefaultCheckpointInterval = 30 * time.Second
+
+// Суффикс файла контрольной точки рядом с выходным файлом
+const checkpointSuffix = ".checkpoint"
+
+// Результат завершённого домена в контрольной точке; Key — ключ настроек
+// (domainCacheKey), при его несовпадении домен обрабатывается заново
+type checkpointEntry struct {
+	Key string `json:"key"`
+	cachedDomain
+}
+
+// Содержимое файла контрольной точки
+type checkpointFile struct {
+	Output  string                     `json:"output"`
+	Updated time.Time                  `json:"updated"`
+	Domains map[string]checkpointEntry `json:"domains"`
+}
+
+// Контрольная точка запуска: успешно обработанные домены с их результатами.
+// Записывается не чаще interval и при завершении Run; при возобновлении
+// домены из неё не обрабатываются повторно. nil-точка ничего не делает.
+type checkpoint struct {
+	mu       sync.Mutex
+	path     string
+	interval time.Duration
+	data     checkpointFile
+	saved    time.Time
+	dirty    bool
+}
+
+// Путь контрольной точки по умолчанию: рядом с выходным файлом
+func checkpointPath(cfg Config) string {
+	if cfg.ContinueFrom != "" {
+		return cfg.ContinueFrom
+	}
+	return cfg.Output + checkpointSuffix
+}
+
+// Контрольная точка для запуска; с cfg.ContinueFrom загружается существующая
+func openCheckpoint(cfg Config) (*checkpoint, error) {
+	if !cfg.Checkpoint && cfg.ContinueFrom == "" {
+		return nil, nil
+	}
+	interval := cfg.CheckpointInterval
+	if interval <= 0 {
+		interval = DefaultCheckpointInterval
+	}
+	cp := &checkpoint{
+		path:     checkpointPath(cfg),
+		interval: interval,
+		data:     checkpointFile{Output: cfg.Output, Domains: make(map[string]checkpointEntry)},
+		saved:    time.Now(),
+	}
+	if cfg.ContinueFrom == "" {
+		return cp, nil
+	}
+
+	data, err := ioutil.ReadFile(cfg.ContinueFrom)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	if err := json.Unmarshal(data, &cp.data); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint %s: %w", cfg.ContinueFrom, err)
+	}
+	if cp.data.Domains == nil {
+		cp.data.Domains = make(map[string]checkpointEntry)
+	}
+	slog.Info("Resuming from checkpoint", "path", cfg.ContinueFrom, "completed", len(cp.data.Domains))
+	return cp, nil
+}
+
+// Результат домена из контрольной точки, если он получен с теми же настройками
+func (c *checkpoint) lookup(domain, key string) (cachedDomain, bool) {
+	if c == nil {
+		return cachedDomain{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.data.Domains[domain]
+	if !ok || entry.Key != key {
+		return cachedDomain{}, false
+	}
+	return entry.cachedDomain, true
+}
+
+// Учёт завершённого домена; файл перезаписывается, если с прошлой записи прошёл интервал
+func (c *checkpoint) record(domain, key string, result cachedDomain) {
+	if c == nil {
+		return
+	}
+	if result.Fetched.IsZero() {
+		result.Fetched = time.Now()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data.Domains[domain] = checkpointEntry{Key: key, cachedDomain: result}
+	c.dirty = true
+	if time.Since(c.saved) >= c.interval {
+		if err := c.saveLocked(); err != nil {
+			slog.Error("Error saving checkpoint", "path", c.path, "error", err)
+		}
+	}
+}
+
+// Запись накопленных изменений, например после прерывания по сигналу
+func (c *checkpoint) flush() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	return c.saveLocked()
+}
+
+func (c *checkpoint) saveLocked() error {
+	c.data.Updated = time.Now().UTC().Truncate(time.Second)
+	data, err := json.Marshal(c.data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoint: %w", err)
+	}
+	if err := writeFileAtomic(c.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	c.saved = time.Now()
+	c.dirty = false
+	slog.Debug("Checkpoint saved", "path", c.path, "completed", len(c.data.Domains))
+	return nil
+}
+
+// Удаление контрольной точки после полностью завершённого запуска
+func (c *checkpoint) remove() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dirty = false
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove checkpoint: %w", err)
+	}
+	return nil
+}
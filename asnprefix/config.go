@@ -0,0 +1,284 @@
+package asnprefix
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Способы резолва доменов
+const (
+	ResolverDig    = "dig"
+	ResolverNative = "native"
+	ResolverDoH    = "doh" // DNS-over-HTTPS (JSON API)
+)
+
+// Whois-клиенты: внешняя команда whois, встроенный клиент или bulk-запрос к Team Cymru
+const (
+	WhoisCommand = "command"
+	WhoisNative  = "native"
+	WhoisBulk    = "bulk"
+
+	whoisIANAServer  = "whois.iana.org"
+	whoisCymruServer = "whois.cymru.com"
+)
+
+// Семейства адресов префиксов в результате
+const (
+	FamilyIPv4 = "4"
+	FamilyIPv6 = "6"
+	FamilyBoth = "both"
+)
+
+// Действия при превышении MaxPrefixes: пропустить AS или оставить первые префиксы
+const (
+	MaxPrefixesSkip     = "skip"
+	MaxPrefixesTruncate = "truncate"
+)
+
+// Config — настройки запуска конвейера домен -> IP -> AS -> префиксы
+type Config struct {
+	Input          string   // Файлы со списком доменов через запятую, "-" — стандартный ввод
+	Domains        []string // Домены, заданные напрямую; объединяются с доменами из Input
+	Limit          int      // Обрабатывать только первые Limit корректных доменов, 0 — все
+	ASNs           []int    // AS, префиксы которых запрашиваются напрямую, без резолва и whois (с теми же фильтрами AS)
+	Output         string   // Выходной файл, "-" — стандартный вывод, пусто — не сохранять
+	OutputDir      string   // Директория для файлов <домен>.json по каждому домену; пусто — не сохранять
+	Format         string
+	LegacyFormat   bool        // Старый формат JSON: префикс в поле hostname и пустое поле ip
+	Flat           bool        // JSON без раздела meta: только массив записей
+	JSONCompact    bool        // JSON без отступов, одной строкой
+	SetName        string      // Базовое имя множеств для форматов ipset и nft
+	Template       string      // Шаблон text/template для каждой записи в формате template
+	TemplateHeader string      // Шаблон, выводимый перед записями
+	TemplateFooter string      // Шаблон, выводимый после записей
+	Append         bool        // Объединять результат с уже существующим выходным файлом
+	OutputMode     os.FileMode // Права выходного файла с учётом umask; 0 — DefaultOutputMode
+	Dedup          bool
+	Aggregate      bool   // Свести префиксы к минимальному набору CIDR
+	Family         string // Семейство адресов выводимых префиксов: 4, 6 или both
+
+	ExcludeCIDRs   []netip.Prefix // Диапазоны, префиксы из которых не попадают в результат
+	ExcludeOverlap bool           // Исключать и префиксы, лишь пересекающиеся с ExcludeCIDRs, а не только содержащиеся в них
+	Sort           bool           // Упорядочить записи по префиксу для стабильного вывода
+
+	MaxPrefixes       int    // Предельное число префиксов одной AS, 0 — без ограничения
+	MaxPrefixesAction string // Что делать с AS сверх предела: skip или truncate
+	Strict            bool   // Некорректная запись домена прерывает запуск, неполный список префиксов AS — ошибка домена
+	DiffAgainst       string // JSON-файл предыдущего запуска для сравнения префиксов
+	IncludeFailed     bool   // Записывать в результат неудачные домены с полем error
+	ASNames           bool   // Добавлять в записи названия AS (Team Cymru)
+	Verify            bool   // Проверять, что каждый префикс анонсируется своей AS (RIPEstat routing-status)
+
+	Concurrency     int
+	Resolver        string
+	DNSResolver     Resolver // Резолвер адресов доменов (и MX/NS, если реализует HostResolver); nil — способ из Resolver
+	DNSServer       string   // DNS-сервер "host:port"; пусто — системный резолвер
+	DoHURL          string   // Адрес DoH-сервера для -resolver doh; пусто — DefaultDoHURL
+	Fixtures        string   // Директория с заготовленными ответами dig, whois и API вместо сети
+	Whois           string
+	WhoisServers    []string // Начальные whois-серверы по порядку отказа; пусто — IANA (или сервер команды whois)
+	ASNRegex        string   // Выражение с одной группой захвата для номера AS в ответе whois; пусто — встроенные
+	ASNMap          string   // Файл JSON или CSV с известными AS адресов и диапазонов; для них whois не запрашивается
+	PrefixSource    string
+	AllowASNs       []int // Если задан, префиксы запрашиваются только для этих AS
+	DenyASNs        []int // AS, префиксы которых не запрашиваются
+	AllowPrivateASN bool  // Запрашивать префиксы частных и зарезервированных AS
+	IncludePrivate  bool  // Обрабатывать частные и зарезервированные адреса наравне с публичными
+	ResolveAllIPs   bool  // Искать AS для всех адресов домена, а не только для первого IPv4 и IPv6
+	IncludeMX       bool  // Дополнительно обрабатывать адреса почтовых серверов из записей MX
+	IncludeNS       bool  // Дополнительно обрабатывать адреса DNS-серверов из записей NS
+	Timeout         time.Duration
+	DomainTimeout   time.Duration // Предельное время обработки одного домена, 0 — без ограничения
+
+	MaxRetries     int
+	RetryBaseDelay time.Duration
+	HTTPClient     *http.Client // Клиент для API префиксов; nil — клиент с таймаутом HTTPTimeout и прокси Proxy
+	Proxy          string       // URL HTTP-прокси для API префиксов; пусто — прокси из окружения
+	UserAgent      string       // Заголовок User-Agent HTTP-запросов; пусто — "maskSites/<версия>"
+
+	// Таймауты отдельных операций, 0 — без отдельного ограничения
+	// (для HTTP — таймаут по умолчанию 10 секунд)
+	HTTPTimeout  time.Duration
+	WhoisTimeout time.Duration
+	DNSTimeout   time.Duration
+
+	MaxResponseBytes int64 // Предельный размер ответа API префиксов, 0 — без ограничения
+
+	// Запросов whois и API префиксов в секунду, 0 — без ограничения.
+	// Ограничение действует в пределах Run.
+	Rate    float64
+	limiter *rateLimiter
+
+	latency      *latencyRecorder    // Задержки запросов по источникам в пределах Run
+	prefixFlight *prefixFlight       // Однократные запросы префиксов каждой AS в пределах Run
+	stream       *ndjsonStream       // Потоковый вывод NDJSON в пределах Run; nil — вывод в конце
+	domainTags   map[string][]string // Метки доменов из комментариев входных файлов
+	asnMap       *asnMap             // Карта из ASNMap, загруженная в Run
+
+	// Контрольная точка для возобновления прерванного запуска: с Checkpoint результаты
+	// завершённых доменов пишутся в "<Output>.checkpoint" не реже CheckpointInterval,
+	// а с ContinueFrom загружаются из этого файла и не запрашиваются повторно
+	Checkpoint         bool
+	ContinueFrom       string
+	CheckpointInterval time.Duration
+	checkpoint         *checkpoint
+
+	// Остановить запуск после стольких сбоев резолвера подряд, 0 — не останавливать
+	MaxResolveFailures int
+	resolveGuard       *resolveGuard
+
+	BreakerThreshold int           // Сбоев API префиксов подряд до размыкания автомата защиты, 0 — без автомата
+	BreakerCooldown  time.Duration // Пауза, на которую размыкается автомат
+	breaker          *circuitBreaker
+
+	CacheDir       string
+	CacheTTL       time.Duration
+	DomainCacheTTL time.Duration // Срок хранения результатов доменов целиком
+	Refresh        bool          // Не использовать кэш, а выполнить все запросы заново и обновить его
+	NoCache        bool
+
+	// Вызывается после обработки каждого домена; может вызываться из разных горутин
+	OnProgress func(done, total int)
+}
+
+// Validate проверяет значения перечислимых настроек
+func (cfg Config) Validate() error {
+	switch cfg.Resolver {
+	case ResolverDig, ResolverNative, ResolverDoH:
+	default:
+		return fmt.Errorf("unknown resolver: %s", cfg.Resolver)
+	}
+
+	if _, err := dnsServerAddr(cfg.DNSServer); err != nil {
+		return err
+	}
+	if cfg.DoHURL != "" {
+		if err := validateDoHURL(cfg.DoHURL); err != nil {
+			return err
+		}
+	}
+
+	switch cfg.Whois {
+	case WhoisCommand, WhoisNative, WhoisBulk:
+	default:
+		return fmt.Errorf("unknown whois client: %s", cfg.Whois)
+	}
+	if _, err := compileASNRegex(cfg.ASNRegex); err != nil {
+		return err
+	}
+	if cfg.Fixtures != "" {
+		if err := validateFixtures(cfg.Fixtures); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Proxy != "" {
+		if _, err := parseProxyURL(cfg.Proxy); err != nil {
+			return err
+		}
+	}
+
+	if _, err := newPrefixProvider(cfg.PrefixSource, apiClient{}); err != nil {
+		return err
+	}
+
+	switch cfg.Format {
+	case FormatJSON, FormatCSV, FormatPlain, FormatNDJSON, FormatTSV:
+	case FormatIPSet, FormatNft:
+		if !isValidSetName(cfg.SetName) {
+			return fmt.Errorf("invalid set name: %q", cfg.SetName)
+		}
+	case FormatTemplate:
+		if _, err := newOutputTemplate(cfg); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown output format: %s", cfg.Format)
+	}
+	if cfg.Format != FormatTemplate && (cfg.Template != "" || cfg.TemplateHeader != "" || cfg.TemplateFooter != "") {
+		return fmt.Errorf("-template is only used with -format %s, not %s", FormatTemplate, cfg.Format)
+	}
+
+	switch cfg.MaxPrefixesAction {
+	case MaxPrefixesSkip, MaxPrefixesTruncate:
+	default:
+		return fmt.Errorf("unknown -max-prefixes action: %s", cfg.MaxPrefixesAction)
+	}
+
+	switch cfg.Family {
+	case FamilyIPv4, FamilyIPv6, FamilyBoth:
+	default:
+		return fmt.Errorf("unknown address family: %s", cfg.Family)
+	}
+
+	if cfg.Append {
+		if cfg.Output == "" || cfg.Output == "-" {
+			return fmt.Errorf("-append requires an output file")
+		}
+		switch cfg.Format {
+		case FormatJSON, FormatNDJSON, FormatPlain:
+		default:
+			return fmt.Errorf("-append is not supported for %s output, use json, ndjson or plain", cfg.Format)
+		}
+	}
+
+	if cfg.Checkpoint && cfg.ContinueFrom == "" && (cfg.Output == "" || cfg.Output == "-") {
+		return fmt.Errorf("-checkpoint requires an output file to store the checkpoint next to")
+	}
+	return nil
+}
+
+// Запуск и поиск внешних программ (dig, whois) и адрес сервера Team Cymru;
+// в тестах подменяются фейками, возвращающими заготовленный вывод
+var (
+	execCommand = exec.CommandContext
+	lookPath    = exec.LookPath
+	cymruServer = whoisCymruServer
+)
+
+// Ошибка запуска внешней программы с первой содержательной строкой её stderr,
+// в которой обычно объясняется причина (например, недоступный сервер)
+func commandError(name string, err error, stderr string) error {
+	for _, line := range strings.Split(stderr, "\n") {
+		line = strings.TrimSpace(strings.TrimLeft(line, ";"))
+		if line != "" {
+			return fmt.Errorf("failed to run %s command: %w: %s", name, err, line)
+		}
+	}
+	return fmt.Errorf("failed to run %s command: %w", name, err)
+}
+
+// Проверка наличия внешних программ, нужных выбранным способам резолва и whois,
+// до начала обработки, а не на каждом домене
+func checkTools(cfg Config) error {
+	// С -fixtures внешние программы не запускаются
+	if cfg.Fixtures != "" {
+		return nil
+	}
+	// Резолвер из cfg.DNSResolver заменяет dig
+	if cfg.Resolver == ResolverDig && cfg.DNSResolver == nil {
+		if _, err := lookPath("dig"); err != nil {
+			return fmt.Errorf("dig not found in PATH: install dnsutils (Debian/Ubuntu) or bind-utils (RHEL/Fedora), or use -resolver native")
+		}
+	}
+	if cfg.Whois == WhoisCommand {
+		if _, err := lookPath("whois"); err != nil {
+			return fmt.Errorf("whois not found in PATH: install the whois package, or use -whois native")
+		}
+	}
+	return nil
+}
+
+// Контекст с таймаутом операции; при timeout <= 0 возвращается исходный контекст
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
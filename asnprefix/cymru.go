@@ -0,0 +1,91 @@
+package asnprefix
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Строка табличного ответа whois Team Cymru вида
+// "AS | IP | BGP Prefix | CC | Registry | Allocated | AS Name"; набор колонок
+// зависит от запроса, отсутствующие поля остаются пустыми
+type cymruRow struct {
+	ASNs      []int // Несколько origin AS перечисляются через пробел
+	IP        string
+	Prefix    string
+	CC        string
+	Registry  string
+	Allocated string
+	ASName    string
+}
+
+// Колонки ответа без заголовка по их числу: запрос по IP (обычный, с -p и -v)
+// и запрос по номеру AS
+var cymruLayouts = map[int][]string{
+	2: {"as", "as name"},
+	3: {"as", "ip", "as name"},
+	4: {"as", "ip", "bgp prefix", "as name"},
+	5: {"as", "cc", "registry", "allocated", "as name"},
+	7: {"as", "ip", "bgp prefix", "cc", "registry", "allocated", "as name"},
+}
+
+// Разбор табличного ответа Team Cymru. Колонки берутся из строки заголовка,
+// а без неё (режим noheader) определяются по числу полей. Строки без номера
+// AS (например, "NA" для адресов без анонса) пропускаются.
+func parseCymruTable(response string) []cymruRow {
+	var header []string
+	var rows []cymruRow
+	for _, line := range strings.Split(response, "\n") {
+		if !strings.Contains(line, "|") {
+			continue
+		}
+		fields := strings.Split(line, "|")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+
+		if strings.EqualFold(fields[0], "AS") {
+			header = make([]string, len(fields))
+			for i, f := range fields {
+				header[i] = strings.ToLower(f)
+			}
+			continue
+		}
+
+		var row cymruRow
+		for _, field := range strings.Fields(fields[0]) {
+			if asNumber, err := strconv.Atoi(field); err == nil {
+				row.ASNs = append(row.ASNs, asNumber)
+			}
+		}
+		if len(row.ASNs) == 0 {
+			continue
+		}
+
+		columns := header
+		if len(columns) != len(fields) {
+			columns = cymruLayouts[len(fields)]
+		}
+		if columns == nil {
+			// Неизвестная раскладка: IP во второй колонке, название — в последней
+			row.IP, row.ASName = fields[1], fields[len(fields)-1]
+		}
+		for i, column := range columns {
+			switch column {
+			case "ip":
+				row.IP = fields[i]
+			case "bgp prefix":
+				row.Prefix = fields[i]
+			case "cc":
+				row.CC = fields[i]
+			case "registry":
+				row.Registry = fields[i]
+			case "allocated":
+				row.Allocated = fields[i]
+			case "as name":
+				row.ASName = fields[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
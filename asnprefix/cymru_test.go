@@ -0,0 +1,65 @@
+package asnprefix
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Ответ whois -h whois.cymru.com " -v 8.8.8.8" с заголовком, а также строкой
+// NA для адреса без анонса
+const cymruVerboseResponse = `AS      | IP               | BGP Prefix          | CC | Registry | Allocated  | AS Name
+15169   | 8.8.8.8          | 8.8.8.0/24          | US | arin     | 2023-12-28 | GOOGLE, US
+NA      | 192.0.2.1        | NA                  |    | other    |            | NA
+13335 209242 | 104.16.132.229 | 104.16.128.0/20 | US | arin     | 2014-03-28 | CLOUDFLARENET, US
+`
+
+func TestParseCymruTableHeader(t *testing.T) {
+	got := parseCymruTable(cymruVerboseResponse)
+	want := []cymruRow{
+		{ASNs: []int{15169}, IP: "8.8.8.8", Prefix: "8.8.8.0/24", CC: "US", Registry: "arin", Allocated: "2023-12-28", ASName: "GOOGLE, US"},
+		{ASNs: []int{13335, 209242}, IP: "104.16.132.229", Prefix: "104.16.128.0/20", CC: "US", Registry: "arin", Allocated: "2014-03-28", ASName: "CLOUDFLARENET, US"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseCymruTable =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestParseCymruTableNoHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     cymruRow
+	}{
+		{"bulk", "15169   | 8.8.8.8          | GOOGLE, US\n",
+			cymruRow{ASNs: []int{15169}, IP: "8.8.8.8", ASName: "GOOGLE, US"}},
+		{"bulk with prefix", "15169   | 8.8.8.8          | 8.8.8.0/24          | GOOGLE, US\n",
+			cymruRow{ASNs: []int{15169}, IP: "8.8.8.8", Prefix: "8.8.8.0/24", ASName: "GOOGLE, US"}},
+		{"as name", "15169   | GOOGLE, US\n",
+			cymruRow{ASNs: []int{15169}, ASName: "GOOGLE, US"}},
+		{"as verbose", "15169   | US | arin     | 2000-03-30 | GOOGLE, US\n",
+			cymruRow{ASNs: []int{15169}, CC: "US", Registry: "arin", Allocated: "2000-03-30", ASName: "GOOGLE, US"}},
+		{"full", "15133   | 93.184.215.14    | 93.184.215.0/24     | US | ripencc  | 2008-06-02 | EDGECAST, US\n",
+			cymruRow{ASNs: []int{15133}, IP: "93.184.215.14", Prefix: "93.184.215.0/24", CC: "US", Registry: "ripencc", Allocated: "2008-06-02", ASName: "EDGECAST, US"}},
+	}
+	for _, tt := range tests {
+		got := parseCymruTable("Bulk mode; whois.cymru.com [2026-10-14 06:00:00 +0000]\n" + tt.response)
+		if len(got) != 1 || !reflect.DeepEqual(got[0], tt.want) {
+			t.Errorf("%s: parseCymruTable = %+v, want [%+v]", tt.name, got, tt.want)
+		}
+	}
+
+	if got := parseCymruTable("NA      | 192.0.2.1        | NA\n"); got != nil {
+		t.Errorf("parseCymruTable of an NA row = %+v, want no rows", got)
+	}
+}
+
+func TestParseASNumbersCymru(t *testing.T) {
+	// Табличный ответ определяется автоматически, строки NA пропускаются
+	want := []int{15169, 13335, 209242}
+	if got := parseASNumbers(cymruVerboseResponse, nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseASNumbers = %v, want %v", got, want)
+	}
+	if got := parseASNumbers("NA      | 192.0.2.1        | NA\n", nil); got != nil {
+		t.Errorf("parseASNumbers of an NA row = %v, want none", got)
+	}
+}
@@ -0,0 +1,121 @@
+package asnprefix
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"sort"
+)
+
+// PrefixDiff — изменения набора префиксов относительно предыдущего запуска
+type PrefixDiff struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+// Запись сохранённого ранее JSON: текущий формат (prefix) или старый (hostname)
+type baselineRecord struct {
+	Prefix   string `json:"prefix"`
+	Hostname string `json:"hostname"`
+}
+
+// Загрузка префиксов из ранее сохранённого JSON-файла.
+// Отсутствующий файл считается пустым набором (первый запуск).
+func loadBaseline(filename string) (map[string]bool, error) {
+	data, err := ioutil.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		slog.Warn("Baseline file not found, treating all prefixes as added", "path", filename)
+		return map[string]bool{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read baseline file: %w", err)
+	}
+
+	// Массив записей (-flat, старый формат) или документ с разделом results
+	var records []baselineRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		var doc struct {
+			Results []baselineRecord `json:"results"`
+		}
+		if docErr := json.Unmarshal(data, &doc); docErr != nil || doc.Results == nil {
+			return nil, fmt.Errorf("failed to parse baseline file %s: %w", filename, err)
+		}
+		records = doc.Results
+	}
+
+	prefixes := make(map[string]bool, len(records))
+	for _, r := range records {
+		raw := r.Prefix
+		if raw == "" {
+			raw = r.Hostname
+		}
+		if raw == "" {
+			continue // Запись о неудачном домене
+		}
+		p, err := parseCIDR(raw)
+		if err != nil {
+			slog.Warn("Skipping invalid prefix in baseline", "path", filename, "prefix", raw)
+			continue
+		}
+		prefixes[p.String()] = true
+	}
+	return prefixes, nil
+}
+
+// Сравнение собранных префиксов с базовым набором
+func diffPrefixes(baseline map[string]bool, records []PrefixRecord) *PrefixDiff {
+	current := make(map[string]bool, len(records))
+	for _, r := range records {
+		if p, err := r.CIDR(); err == nil {
+			current[p.String()] = true
+		}
+	}
+
+	diff := &PrefixDiff{Added: []string{}, Removed: []string{}}
+	for prefix := range current {
+		if !baseline[prefix] {
+			diff.Added = append(diff.Added, prefix)
+		}
+	}
+	for prefix := range baseline {
+		if !current[prefix] {
+			diff.Removed = append(diff.Removed, prefix)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	return diff
+}
+
+// WriteDiff выводит изменения построчно: "+" — новый префикс, "-" — пропавший
+func WriteDiff(w io.Writer, diff *PrefixDiff) error {
+	for _, prefix := range diff.Added {
+		if _, err := fmt.Fprintf(w, "+ %s\n", prefix); err != nil {
+			return err
+		}
+	}
+	for _, prefix := range diff.Removed {
+		if _, err := fmt.Fprintf(w, "- %s\n", prefix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveDiff атомарно сохраняет изменения префиксов в JSON-файл с правами mode,
+// как у выходного файла; 0 — права по умолчанию
+func SaveDiff(filename string, diff *PrefixDiff, mode os.FileMode) error {
+	jsonData, err := json.MarshalIndent(diff, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal diff: %w", err)
+	}
+
+	if err := writeFileAtomic(filename, jsonData, outputMode(Config{OutputMode: mode})); err != nil {
+		return fmt.Errorf("failed to write diff file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package asnprefix
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSaveDiff(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "diff.json")
+	diff := &PrefixDiff{Added: []string{"93.184.215.0/24"}, Removed: []string{"2606:2800::/32"}}
+
+	if err := SaveDiff(path, diff, 0600); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("diff file mode = %o, want 600", perm)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got PrefixDiff
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&got, diff) {
+		t.Errorf("saved diff = %+v, want %+v", got, *diff)
+	}
+
+	// Временный файл после переименования не остаётся
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("directory has %d entries, want only the diff file", len(entries))
+	}
+}
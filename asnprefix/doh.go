@@ -0,0 +1,161 @@
+package asnprefix
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// DoH-сервер по умолчанию для -resolver doh
+const DefaultDoHURL = "https://cloudflare-dns.com/dns-query"
+
+// Числовые типы записей DNS в JSON-ответе DoH
+const (
+	dnsTypeA    = 1
+	dnsTypeNS   = 2
+	dnsTypeMX   = 15
+	dnsTypeAAAA = 28
+)
+
+// Коды ответа DNS (поле Status JSON-ответа)
+const (
+	dnsRcodeSuccess  = 0
+	dnsRcodeNXDomain = 3
+)
+
+// JSON-ответ DoH (application/dns-json, формат Cloudflare и Google)
+type dohResponse struct {
+	Status int `json:"Status"`
+	Answer []struct {
+		Name string `json:"name"`
+		Type int    `json:"type"`
+		Data string `json:"data"`
+	} `json:"Answer"`
+}
+
+// Резолв через DNS-over-HTTPS с JSON API; HTTP-клиент тот же, что у API
+// префиксов, поэтому действуют -proxy и -http-timeout
+type dohResolver struct {
+	endpoint string
+	api      apiClient
+}
+
+// DoH-резолвер для endpoint с HTTP-клиентом из настроек
+func newDoHResolver(endpoint string, cfg Config) (dohResolver, error) {
+	if endpoint == "" {
+		endpoint = DefaultDoHURL
+	}
+	if err := validateDoHURL(endpoint); err != nil {
+		return dohResolver{}, err
+	}
+
+	client := cfg.HTTPClient
+	if client == nil {
+		var err error
+		if client, err = newHTTPClient(cfg.HTTPTimeout, cfg.Proxy); err != nil {
+			return dohResolver{}, err
+		}
+	}
+	api := newAPIClient(client, cfg)
+	api.accept = "application/dns-json"
+	return dohResolver{endpoint: endpoint, api: api}, nil
+}
+
+// Адрес DoH-сервера, если выбран резолвер doh; для других способов — пусто
+func dohURL(cfg Config) string {
+	if cfg.Resolver != ResolverDoH {
+		return ""
+	}
+	if cfg.DoHURL == "" {
+		return DefaultDoHURL
+	}
+	return cfg.DoHURL
+}
+
+// Проверка адреса DoH-сервера: нужен абсолютный http(s) URL
+func validateDoHURL(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("invalid DoH URL: %q", endpoint)
+	}
+	return nil
+}
+
+func (r dohResolver) Resolve(ctx context.Context, domain string) ([]netip.Addr, error) {
+	var addrs []netip.Addr
+	for _, qtype := range []int{dnsTypeA, dnsTypeAAAA} {
+		answers, err := r.query(ctx, domain, qtype)
+		if err != nil {
+			return nil, err
+		}
+		// Ответ может содержать цепочку CNAME: берутся только адреса
+		for _, data := range answers {
+			if addr, err := netip.ParseAddr(data); err == nil {
+				addrs = append(addrs, addr.Unmap())
+			}
+		}
+	}
+	return addrs, nil
+}
+
+// Имена из записей MX или NS домена
+func (r dohResolver) LookupHosts(ctx context.Context, domain, recordType string) ([]string, error) {
+	qtype := dnsTypeMX
+	if recordType == RecordNS {
+		qtype = dnsTypeNS
+	}
+	answers, err := r.query(ctx, domain, qtype)
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []string
+	for _, data := range answers {
+		// Данные MX имеют вид "10 mx.example.com.", NS — "ns1.example.com."
+		fields := strings.Fields(data)
+		if len(fields) == 0 {
+			continue
+		}
+		if host := strings.TrimSuffix(fields[len(fields)-1], "."); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts, nil
+}
+
+// Запрос записей одного типа; возвращает поле data ответов этого типа.
+// NXDOMAIN возвращается как *net.DNSError с IsNotFound, как у встроенного резолвера.
+func (r dohResolver) query(ctx context.Context, domain string, qtype int) ([]string, error) {
+	u, err := url.Parse(r.endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DoH URL: %q", r.endpoint)
+	}
+	q := u.Query()
+	q.Set("name", domain)
+	q.Set("type", fmt.Sprint(qtype))
+	u.RawQuery = q.Encode()
+
+	var resp dohResponse
+	if err := r.api.fetchJSON(ctx, u.String(), &resp); err != nil {
+		return nil, fmt.Errorf("failed to resolve %s via DoH: %w", domain, err)
+	}
+
+	switch resp.Status {
+	case dnsRcodeSuccess:
+	case dnsRcodeNXDomain:
+		return nil, fmt.Errorf("failed to resolve %s: %w", domain, &net.DNSError{Err: "no such host", Name: domain, Server: u.Host, IsNotFound: true})
+	default:
+		return nil, fmt.Errorf("failed to resolve %s: %w", domain, &net.DNSError{Err: fmt.Sprintf("DNS response code %d", resp.Status), Name: domain, Server: u.Host})
+	}
+
+	var data []string
+	for _, answer := range resp.Answer {
+		if answer.Type == qtype {
+			data = append(data, answer.Data)
+		}
+	}
+	return data, nil
+}
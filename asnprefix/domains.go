@@ -0,0 +1,266 @@
+package asnprefix
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LoadDomains читает список доменов из cfg.Domains и cfg.Input и приводит записи
+// к именам хостов, не обращаясь к DNS. Домены из нескольких источников
+// объединяются без повторов; загрузка списков по URL прерывается отменой ctx.
+func LoadDomains(ctx context.Context, cfg Config) ([]string, error) {
+	domains, _, err := loadDomainList(ctx, cfg)
+	return domains, err
+}
+
+// Список доменов вместе с метками из комментариев входных файлов (см. splitAnnotation).
+// Метки повторяющегося домена объединяются.
+func loadDomainList(ctx context.Context, cfg Config) ([]string, map[string][]string, error) {
+	if len(cfg.Domains) == 0 && cfg.Input == "" {
+		return nil, nil, fmt.Errorf("failed to read domains: no input files or domains given")
+	}
+
+	var domains []string
+	seen := make(map[string]bool)
+	tags := make(map[string][]string)
+	add := func(entries []string, source string) error {
+		for _, line := range entries {
+			entry, entryTags := splitAnnotation(line)
+			// Приводим записи к именам хостов до обращения к dig
+			domain, err := normalizeDomain(entry)
+			if err != nil {
+				if cfg.Strict {
+					return fmt.Errorf("%s: %w", source, err)
+				}
+				slog.Warn("Skipping invalid domain entry", "entry", entry, "error", err)
+				continue
+			}
+
+			tags[domain] = mergeTags(tags[domain], entryTags)
+			if seen[domain] {
+				slog.Debug("Skipping duplicate domain", "domain", domain, "source", source)
+				continue
+			}
+			seen[domain] = true
+			slog.Debug("Domain loaded", "domain", domain, "source", source, "tags", entryTags)
+			domains = append(domains, domain)
+		}
+		return nil
+	}
+
+	if len(cfg.Domains) > 0 {
+		if err := add(cfg.Domains, "-domains"); err != nil {
+			return nil, nil, err
+		}
+	}
+	if cfg.Input == "" {
+		return limitDomains(domains, cfg.Limit), tags, nil
+	}
+
+	files, err := expandInputs(cfg.Input)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read domains: %w", err)
+	}
+	for _, file := range files {
+		// Чтение списка доменов из файла или по URL
+		var entries []string
+		if isURL(file) {
+			entries, err = readDomainsFromURL(ctx, file, cfg)
+		} else {
+			entries, err = readDomainsFromFile(file)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read domains from %s: %w", file, err)
+		}
+		if err := add(entries, file); err != nil {
+			return nil, nil, err
+		}
+	}
+	return limitDomains(domains, cfg.Limit), tags, nil
+}
+
+// Первые limit доменов списка; limit <= 0 означает весь список
+func limitDomains(domains []string, limit int) []string {
+	if limit > 0 && len(domains) > limit {
+		slog.Info("Limiting domain list", "limit", limit, "total", len(domains))
+		return domains[:limit]
+	}
+	return domains
+}
+
+// Разворачивание -input в список файлов: пути через запятую, шаблоны glob
+// и директории, из которых берутся файлы *.txt
+func expandInputs(input string) ([]string, error) {
+	var files []string
+	for _, path := range strings.Split(input, ",") {
+		path = strings.TrimSpace(path)
+		switch {
+		case path == "":
+			continue
+		case path == "-", isURL(path):
+			files = append(files, path)
+		case strings.ContainsAny(path, "*?["):
+			matches, err := filepath.Glob(path)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", path, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match %q", path)
+			}
+			files = append(files, matches...)
+		default:
+			info, err := os.Stat(path)
+			if err != nil || !info.IsDir() {
+				// Ошибку отсутствующего файла сообщит чтение
+				files = append(files, path)
+				continue
+			}
+			matches, err := filepath.Glob(filepath.Join(path, "*.txt"))
+			if err != nil {
+				return nil, err
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no *.txt files in directory %s", path)
+			}
+			files = append(files, matches...)
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no input files given")
+	}
+	return files, nil
+}
+
+// Является ли элемент -input адресом http:// или https://
+func isURL(path string) bool {
+	lower := strings.ToLower(path)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// Загрузка списка доменов по URL с таймаутом, прокси и ограничением размера
+// ответа из cfg; тело разбирается так же, как локальный файл
+func readDomainsFromURL(ctx context.Context, rawURL string, cfg Config) ([]string, error) {
+	client := cfg.HTTPClient
+	if client == nil {
+		var err error
+		if client, err = newHTTPClient(cfg.HTTPTimeout, cfg.Proxy); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid domain list URL: %w", err)
+	}
+	req.Header.Set("User-Agent", userAgent(cfg))
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch domain list: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch domain list: unexpected status %s", resp.Status)
+	}
+
+	var body io.Reader = resp.Body
+	if cfg.MaxResponseBytes > 0 {
+		body = io.LimitReader(resp.Body, cfg.MaxResponseBytes+1)
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read domain list: %w", err)
+	}
+	if cfg.MaxResponseBytes > 0 && int64(len(data)) > cfg.MaxResponseBytes {
+		return nil, fmt.Errorf("domain list: %w: limit is %d bytes", ErrResponseTooLarge, cfg.MaxResponseBytes)
+	}
+	return parseDomains(string(data)), nil
+}
+
+// Функция для чтения доменов из файла; имя "-" означает стандартный ввод
+func readDomainsFromFile(filename string) ([]string, error) {
+	var data []byte
+	var err error
+	if filename == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(filename)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	return parseDomains(string(data)), nil
+}
+
+// Разбор списка доменов: по домену на строке, пустые строки и комментарии (#) пропускаются;
+// комментарий в конце строки с метками домена остаётся в записи для splitAnnotation.
+// Окончания строк \r\n и метка порядка байтов UTF-8 в начале файла тоже поддерживаются.
+// Для пустого файла возвращается пустой список, а не список из одной пустой строки.
+func parseDomains(data string) []string {
+	data = strings.TrimPrefix(data, "\ufeff")
+
+	var domains []string
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains = append(domains, line)
+	}
+	return domains
+}
+
+// Приведение записи к имени хоста: убираются схема, учётные данные, путь, порт и пробелы
+func normalizeDomain(entry string) (string, error) {
+	host := strings.TrimSpace(entry)
+
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	if i := strings.IndexAny(host, "/?#"); i >= 0 {
+		host = host[:i]
+	}
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+
+	// Интернационализированные имена резолвятся в форме punycode
+	host, err := toASCII(host)
+	if err != nil {
+		return "", fmt.Errorf("invalid domain entry %q: %w", entry, err)
+	}
+	if !isValidHostname(host) {
+		return "", fmt.Errorf("invalid domain entry: %q", entry)
+	}
+	return host, nil
+}
+
+// Проверка имени хоста: метки из букв, цифр и дефисов длиной до 63 символов, всего до 253
+func isValidHostname(host string) bool {
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
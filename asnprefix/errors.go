@@ -0,0 +1,66 @@
+package asnprefix
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Виды ошибок конвейера для проверки через errors.Is и errors.As. Ошибки
+// доменов в Result.Errors оборачивают их вместе с исходной причиной.
+var (
+	// ErrNoDomains — во входных данных нет ни одного домена (например, файл пуст
+	// или содержит только комментарии), а AS напрямую не заданы
+	ErrNoDomains = errors.New("no domains to process")
+
+	// ErrResolve — резолв домена не удался (ошибка dig или DNS-запроса)
+	ErrResolve = errors.New("error getting IPs")
+	// ErrNoIPs — домен резолвится, но у него нет адресов A и AAAA
+	ErrNoIPs = errors.New("no IPs found")
+	// ErrNoPublicIPs — у домена только частные и зарезервированные адреса
+	ErrNoPublicIPs = errors.New("resolves only to non-public IPs")
+	// ErrResolverUnreachable — запуск остановлен после серии сбоев резолвера
+	ErrResolverUnreachable = errors.New("resolver appears unreachable")
+
+	// ErrASNNotFound — whois ответил, но номера AS в ответе нет; такой ответ
+	// не повторяется на других серверах
+	ErrASNNotFound = errors.New("AS number not found in whois response")
+	// ErrNoAllowedASNs — AS домена найдены, но все отброшены -allow-asn, -deny-asn
+	// или проверкой частных AS
+	ErrNoAllowedASNs = errors.New("no allowed AS numbers found")
+
+	// ErrTooManyPrefixes — AS анонсирует больше префиксов, чем разрешает -max-prefixes
+	ErrTooManyPrefixes = errors.New("too many prefixes")
+
+	// ErrAPIStatus — API префиксов ответило кодом, отличным от 200 (подробности в APIStatusError)
+	ErrAPIStatus = errors.New("unexpected API status")
+	// ErrUnexpectedResponse — ответ API не соответствует ожидаемой схеме (например,
+	// страница ошибки или изменившийся формат), в отличие от AS без префиксов
+	ErrUnexpectedResponse = errors.New("unexpected API response")
+	// ErrResponseTooLarge — ответ превысил -max-response-bytes
+	ErrResponseTooLarge = errors.New("response is too large")
+	// ErrTruncatedResponse — с -strict: API вернуло меньше префиксов, чем указано в Total
+	ErrTruncatedResponse = errors.New("prefix list is truncated")
+
+	// ErrCircuitOpen — запрос префиксов не отправлен: после серии сбоев API
+	// автомат защиты разомкнут на время -breaker-cooldown
+	ErrCircuitOpen = errors.New("prefix API circuit breaker is open")
+
+	// ErrNoFixture — в директории -fixtures нет заготовленного ответа на запрос
+	ErrNoFixture = errors.New("no fixture for request")
+)
+
+// APIStatusError — ответ API с кодом, отличным от 200; errors.Is(err, ErrAPIStatus) для него истинно
+type APIStatusError struct {
+	StatusCode int
+	Status     string
+	RetryAfter time.Duration // Пауза из заголовка Retry-After; 0, если заголовка нет
+}
+
+func (e *APIStatusError) Error() string {
+	return fmt.Sprintf("received non-200 response: %s", e.Status)
+}
+
+func (e *APIStatusError) Is(target error) bool {
+	return target == ErrAPIStatus
+}
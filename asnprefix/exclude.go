@@ -0,0 +1,76 @@
+package asnprefix
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"strings"
+)
+
+// ParseCIDRList разбирает список CIDR через запятую; биты хоста обнуляются,
+// пустые элементы пропускаются
+func ParseCIDRList(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", item, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// Исключённый диапазон, под который попадает префикс: целиком содержащий его,
+// а с overlap — любой пересекающийся с ним
+func excludedBy(p netip.Prefix, excluded []netip.Prefix, overlap bool) (netip.Prefix, bool) {
+	for _, ex := range excluded {
+		if overlap && ex.Overlaps(p) {
+			return ex, true
+		}
+		if ex.Bits() <= p.Bits() && ex.Contains(p.Addr()) {
+			return ex, true
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+// Записи без префиксов из исключённых диапазонов cfg.ExcludeCIDRs;
+// записи об ошибках сохраняются
+func excludeRecords(records []PrefixRecord, cfg Config) []PrefixRecord {
+	if len(cfg.ExcludeCIDRs) == 0 {
+		return records
+	}
+	filtered := records[:0:0]
+	for _, r := range records {
+		p, err := r.CIDR()
+		if err != nil {
+			filtered = append(filtered, r)
+			continue
+		}
+		if ex, ok := excludedBy(p, cfg.ExcludeCIDRs, cfg.ExcludeOverlap); ok {
+			slog.Info("Excluding prefix", "domain", r.Domain, "prefix", r.Prefix, "excluded_by", ex.String())
+			continue
+		}
+		filtered = append(filtered, r)
+	}
+	return filtered
+}
+
+// Префиксы в текстовом виде; nil для пустого списка
+func prefixStrings(prefixes []netip.Prefix) []string {
+	var list []string
+	for _, p := range prefixes {
+		list = append(list, p.String())
+	}
+	return list
+}
+
+// Фильтры выводимых записей: семейство адресов и исключённые диапазоны
+func filterOutput(records []PrefixRecord, cfg Config) []PrefixRecord {
+	return excludeRecords(filterFamily(records, cfg.Family), cfg)
+}
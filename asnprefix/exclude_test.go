@@ -0,0 +1,97 @@
+package asnprefix
+
+import (
+	"encoding/json"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseCIDRList(t *testing.T) {
+	got, err := ParseCIDRList(" 10.0.0.1/8, ,2001:db8::1/32,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::/32")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseCIDRList = %v, want %v", got, want)
+	}
+
+	if _, err := ParseCIDRList("10.0.0.0/8,bogus"); err == nil {
+		t.Error("ParseCIDRList accepted an invalid CIDR")
+	}
+}
+
+func TestExcludedBy(t *testing.T) {
+	excluded := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.1.0/24"),
+		netip.MustParsePrefix("2001:db8::/32"),
+		netip.MustParsePrefix("2001:db9:1::/48"),
+	}
+	tests := []struct {
+		prefix  string
+		overlap bool
+		want    string // Исключивший диапазон; пусто — префикс не исключён
+	}{
+		{"10.1.0.0/16", false, "10.0.0.0/8"},
+		{"10.0.0.0/8", false, "10.0.0.0/8"},
+		{"8.0.0.0/6", false, ""}, // Шире исключённого диапазона
+		{"8.0.0.0/6", true, "10.0.0.0/8"},
+		{"192.168.0.0/16", false, ""},
+		{"192.168.0.0/16", true, "192.168.1.0/24"},
+		{"192.0.2.0/24", true, ""},
+		{"2001:db8:ff::/48", false, "2001:db8::/32"},
+		{"2001:db9::/32", false, ""},
+		{"2001:db9::/32", true, "2001:db9:1::/48"},
+		{"2001:dba::/32", true, ""},
+		{"::ffff:10.0.0.0/104", true, ""}, // IPv6 не пересекается с IPv4
+	}
+	for _, tt := range tests {
+		ex, ok := excludedBy(netip.MustParsePrefix(tt.prefix), excluded, tt.overlap)
+		got := ""
+		if ok {
+			got = ex.String()
+		}
+		if got != tt.want {
+			t.Errorf("excludedBy(%s, overlap=%t) = %q, want %q", tt.prefix, tt.overlap, got, tt.want)
+		}
+	}
+}
+
+func TestSaveDomainFilesExcludes(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		ExcludeCIDRs: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")},
+		Family:       FamilyBoth,
+	}
+	domains := []string{"example.com"}
+	perDomain := [][]PrefixRecord{{
+		{Domain: "example.com", IP: "192.0.2.1", ASN: 64500, Prefix: "192.0.2.0/25"},
+		{Domain: "example.com", IP: "198.51.100.1", ASN: 64501, Prefix: "198.51.100.0/24"},
+		{Domain: "example.com", IP: "2001:db8::1", ASN: 64501, Prefix: "2001:db8::/32"},
+	}}
+	if err := saveDomainFiles(dir, domains, perDomain, []error{nil}, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "example.com.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file domainFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	want := domainFile{
+		Domain:   "example.com",
+		IPs:      []string{"198.51.100.1", "2001:db8::1"},
+		ASNs:     []int{64501},
+		Prefixes: []string{"198.51.100.0/24", "2001:db8::/32"},
+	}
+	if !reflect.DeepEqual(file, want) {
+		t.Errorf("domain file = %+v, want %+v", file, want)
+	}
+}
@@ -0,0 +1,286 @@
+package asnprefix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// Заготовленный результат запуска внешней программы
+type fakeResult struct {
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+	Exit   int    `json:"exit"`
+}
+
+// Подмена execCommand и lookPath до конца теста: программа запускается как
+// тестовый бинарник в режиме TestHelperProcess и выводит результат, заданный
+// для строки "имя аргументы...". Неизвестная команда завершается с кодом 127.
+func fakeCommands(t *testing.T, results map[string]fakeResult) {
+	t.Helper()
+	data, err := json.Marshal(results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "commands.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	savedExec, savedLookPath := execCommand, lookPath
+	t.Cleanup(func() { execCommand, lookPath = savedExec, savedLookPath })
+	execCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		helperArgs := append([]string{"-test.run=^TestHelperProcess$", "--", name}, args...)
+		cmd := exec.CommandContext(ctx, os.Args[0], helperArgs...)
+		cmd.Env = append(os.Environ(), "GO_WANT_HELPER_PROCESS=1", "FAKE_COMMANDS="+path)
+		return cmd
+	}
+	lookPath = func(file string) (string, error) {
+		return "/usr/bin/" + file, nil
+	}
+}
+
+// Фейковая внешняя программа для fakeCommands; в обычном запуске тестов ничего не делает
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	command := strings.Join(args[1:], " ")
+
+	var results map[string]fakeResult
+	data, err := os.ReadFile(os.Getenv("FAKE_COMMANDS"))
+	if err == nil {
+		err = json.Unmarshal(data, &results)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	result, ok := results[command]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unexpected command: %s\n", command)
+		os.Exit(127)
+	}
+	fmt.Fprint(os.Stdout, result.Stdout)
+	fmt.Fprint(os.Stderr, result.Stderr)
+	os.Exit(result.Exit)
+}
+
+// Аргументы dig для запроса адресов имени, как у getIPsByDig
+func digArgs(name string) string {
+	return fmt.Sprintf("dig +noall +comments +answer %s A %s AAAA", name, name)
+}
+
+func TestGetIPsByDigCommand(t *testing.T) {
+	fakeCommands(t, map[string]fakeResult{
+		digArgs("example.com"): {Stdout: `;; Got answer:
+;; ->>HEADER<<- opcode: QUERY, status: NOERROR, id: 1
+example.com.		300	IN	A	93.184.215.14
+;; Got answer:
+;; ->>HEADER<<- opcode: QUERY, status: NOERROR, id: 2
+example.com.		300	IN	AAAA	2606:2800:21f:cb07:6820:80da:af6b:8b2c
+`},
+		digArgs("empty.example"): {},
+		digArgs("down.example"): {
+			Stdout: ";; connection timed out; no servers could be reached\n",
+			Exit:   9,
+		},
+		digArgs("broken.example"): {Stderr: "dig: couldn't get address for 'ns.invalid': not found\n", Exit: 10},
+	})
+	ctx := context.Background()
+
+	ips, err := getIPsByDig(ctx, "example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"93.184.215.14", "2606:2800:21f:cb07:6820:80da:af6b:8b2c"}; !reflect.DeepEqual(ips, want) {
+		t.Errorf("getIPsByDig = %v, want %v", ips, want)
+	}
+
+	// Пустой вывод без кода ответа — имя без адресов, а не ошибка
+	if ips, err := getIPsByDig(ctx, "empty.example", ""); err != nil || ips != nil {
+		t.Errorf("getIPsByDig with empty output = %v, %v; want nil, nil", ips, err)
+	}
+
+	// Причина сбоя берётся из stdout, если dig ничего не написал в stderr
+	_, err = getIPsByDig(ctx, "down.example", "")
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 9 {
+		t.Errorf("getIPsByDig on exit 9: %v, want an *exec.ExitError with code 9", err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "connection timed out; no servers could be reached") {
+		t.Errorf("getIPsByDig error %q does not explain the failure", err)
+	}
+
+	_, err = getIPsByDig(ctx, "broken.example", "")
+	if want := "failed to run dig command: exit status 10: dig: couldn't get address for 'ns.invalid': not found"; err == nil || err.Error() != want {
+		t.Errorf("getIPsByDig error = %v, want %q", err, want)
+	}
+}
+
+func TestGetHostsByDigCommand(t *testing.T) {
+	fakeCommands(t, map[string]fakeResult{
+		"dig +short example.com MX":                   {Stdout: "10 mx1.example.com.\n20 mx2.example.com.\n"},
+		"dig @192.0.2.53 -p 53 +short example.com NS": {Stdout: "ns1.example.com.\nns2.example.com.\n"},
+		"dig +short empty.example MX":                 {},
+	})
+	ctx := context.Background()
+
+	hosts, err := getHostsByDig(ctx, "example.com", RecordMX, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"mx1.example.com", "mx2.example.com"}; !reflect.DeepEqual(hosts, want) {
+		t.Errorf("getHostsByDig MX = %v, want %v", hosts, want)
+	}
+
+	hosts, err = getHostsByDig(ctx, "example.com", RecordNS, "192.0.2.53:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"ns1.example.com", "ns2.example.com"}; !reflect.DeepEqual(hosts, want) {
+		t.Errorf("getHostsByDig NS = %v, want %v", hosts, want)
+	}
+
+	if hosts, err := getHostsByDig(ctx, "empty.example", RecordMX, ""); err != nil || hosts != nil {
+		t.Errorf("getHostsByDig with empty output = %v, %v; want nil, nil", hosts, err)
+	}
+}
+
+func TestGetASNumberByWhoisCommand(t *testing.T) {
+	fakeCommands(t, map[string]fakeResult{
+		"whois 93.184.215.14": {Stdout: `NetRange:       93.184.208.0 - 93.184.223.255
+CIDR:           93.184.208.0/20
+NetName:        EDGECAST-NETBLK-03
+OriginAS:       AS15133
+Organization:   Edgecast Inc. (EC-1)
+`},
+		"whois 192.0.2.1":                      {Stdout: ""},
+		"whois -h whois.example.net 192.0.2.2": {Stderr: "fgets: Connection reset by peer\n", Exit: 1},
+	})
+	ctx := context.Background()
+
+	asNumbers, err := getASNumberByWhois(ctx, "93.184.215.14", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{15133}; !reflect.DeepEqual(asNumbers, want) {
+		t.Errorf("getASNumberByWhois = %v, want %v", asNumbers, want)
+	}
+
+	// Пустой ответ: номера AS нет, на других серверах запрос не повторяется
+	if _, err := getASNumberByWhois(ctx, "192.0.2.1", "", nil); !errors.Is(err, ErrASNNotFound) {
+		t.Errorf("getASNumberByWhois with empty output: %v, want ErrASNNotFound", err)
+	}
+
+	_, err = getASNumberByWhois(ctx, "192.0.2.2", "whois.example.net", nil)
+	if want := "failed to run whois command: exit status 1: fgets: Connection reset by peer"; err == nil || err.Error() != want {
+		t.Errorf("getASNumberByWhois error = %v, want %q", err, want)
+	}
+
+	// -asn-regex заменяет встроенный разбор ответа
+	re := regexp.MustCompile(`NetName:\s*EDGECAST-NETBLK-(\d+)`)
+	if asNumbers, err := getASNumberByWhois(ctx, "93.184.215.14", "", re); err != nil || !reflect.DeepEqual(asNumbers, []int{3}) {
+		t.Errorf("getASNumberByWhois with -asn-regex = %v, %v; want [3]", asNumbers, err)
+	}
+}
+
+func TestCommandError(t *testing.T) {
+	runErr := errors.New("exit status 9")
+	tests := []struct {
+		stderr string
+		want   string
+	}{
+		{"", "failed to run dig command: exit status 9"},
+		{"\n  \n", "failed to run dig command: exit status 9"},
+		{";; connection timed out; no servers could be reached\n;; extra\n", "failed to run dig command: exit status 9: connection timed out; no servers could be reached"},
+		{"\n\n  dig: invalid option\n", "failed to run dig command: exit status 9: dig: invalid option"},
+	}
+	for _, tt := range tests {
+		err := commandError("dig", runErr, tt.stderr)
+		if err.Error() != tt.want {
+			t.Errorf("commandError(%q) = %q, want %q", tt.stderr, err, tt.want)
+		}
+		if !errors.Is(err, runErr) {
+			t.Errorf("commandError(%q) does not wrap the run error", tt.stderr)
+		}
+	}
+}
+
+func TestGetIPsByDigFollowsCNAME(t *testing.T) {
+	// Сервер вернул только CNAME: адреса запрашиваются для последнего имени цепочки
+	fakeCommands(t, map[string]fakeResult{
+		digArgs("www.example.com"): {Stdout: `;; ->>HEADER<<- opcode: QUERY, status: NOERROR, id: 1
+www.example.com.	300	IN	CNAME	cdn.example.net.
+cdn.example.net.	300	IN	CNAME	edge.example.net.
+;; ->>HEADER<<- opcode: QUERY, status: NOERROR, id: 2
+www.example.com.	300	IN	CNAME	cdn.example.net.
+cdn.example.net.	300	IN	CNAME	edge.example.net.
+`},
+		digArgs("edge.example.net"): {Stdout: `;; ->>HEADER<<- opcode: QUERY, status: NOERROR, id: 3
+edge.example.net.	20	IN	A	93.184.215.14
+;; ->>HEADER<<- opcode: QUERY, status: NOERROR, id: 4
+`},
+		digArgs("loop.example"): {Stdout: "loop.example.\t300\tIN\tCNAME\tloop.example.\n"},
+	})
+
+	ips, err := getIPsByDig(context.Background(), "www.example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"93.184.215.14"}; !reflect.DeepEqual(ips, want) {
+		t.Errorf("getIPsByDig = %v, want %v", ips, want)
+	}
+
+	if _, err := getIPsByDig(context.Background(), "loop.example", ""); err == nil || !strings.Contains(err.Error(), "CNAME chain") {
+		t.Errorf("getIPsByDig on a CNAME loop: %v, want a CNAME chain error", err)
+	}
+}
+
+func TestGetIPsByDigStatusWithZeroExit(t *testing.T) {
+	// dig завершается с кодом 0 и при NXDOMAIN, и при SERVFAIL: причина только в заголовке
+	fakeCommands(t, map[string]fakeResult{
+		digArgs("gone.example"): {Stdout: `;; ->>HEADER<<- opcode: QUERY, status: NXDOMAIN, id: 1
+;; ->>HEADER<<- opcode: QUERY, status: NXDOMAIN, id: 2
+`},
+		digArgs("broken.example"): {Stdout: `;; ->>HEADER<<- opcode: QUERY, status: SERVFAIL, id: 3
+;; ->>HEADER<<- opcode: QUERY, status: SERVFAIL, id: 4
+`},
+		digArgs("mailonly.example"): {Stdout: `;; ->>HEADER<<- opcode: QUERY, status: NOERROR, id: 5
+;; ->>HEADER<<- opcode: QUERY, status: NOERROR, id: 6
+`},
+	})
+	ctx := context.Background()
+
+	_, err := getIPsByDig(ctx, "gone.example", "")
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Errorf("getIPsByDig on NXDOMAIN: %v, want a not-found *net.DNSError", err)
+	}
+
+	_, err = getIPsByDig(ctx, "broken.example", "")
+	if !errors.As(err, &dnsErr) || dnsErr.IsNotFound || !dnsErr.IsTemporary {
+		t.Errorf("getIPsByDig on SERVFAIL: %v, want a temporary *net.DNSError", err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "SERVFAIL") {
+		t.Errorf("getIPsByDig error %q does not name the status", err)
+	}
+
+	if ips, err := getIPsByDig(ctx, "mailonly.example", ""); err != nil || ips != nil {
+		t.Errorf("getIPsByDig on NOERROR without records = %v, %v; want nil, nil", ips, err)
+	}
+}
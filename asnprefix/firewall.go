@@ -0,0 +1,100 @@
+package asnprefix
+
+import (
+	"bytes"
+	"fmt"
+	"net/netip"
+)
+
+// Таблица nftables, в которой создаются множества формата nft
+const nftTable = "inet filter"
+
+// Проверка имени множества: буквы, цифры, '_' и '-', не длиннее 24 символов,
+// чтобы с суффиксом _v4/_v6 уложиться в ограничение ipset в 31 символ
+func isValidSetName(name string) bool {
+	if name == "" || len(name) > 24 {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// Разделение префиксов по семействам адресов; некорректные и повторяющиеся пропускаются
+func splitByFamily(data []PrefixRecord) (v4, v6 []netip.Prefix) {
+	seen := make(map[netip.Prefix]bool)
+	for _, r := range data {
+		p, err := r.CIDR()
+		if err != nil {
+			continue
+		}
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+
+		if p.Addr().Is4() {
+			v4 = append(v4, p)
+		} else {
+			v6 = append(v6, p)
+		}
+	}
+	return v4, v6
+}
+
+// Файл для ipset restore: множества hash:net <name>_v4 и <name>_v6
+func encodeIPSet(data []PrefixRecord, setName string) []byte {
+	v4, v6 := splitByFamily(data)
+
+	var buf bytes.Buffer
+	for _, set := range []struct {
+		name     string
+		family   string
+		prefixes []netip.Prefix
+	}{
+		{setName + "_v4", "inet", v4},
+		{setName + "_v6", "inet6", v6},
+	} {
+		fmt.Fprintf(&buf, "create %s hash:net family %s -exist\n", set.name, set.family)
+		fmt.Fprintf(&buf, "flush %s\n", set.name)
+		for _, p := range set.prefixes {
+			fmt.Fprintf(&buf, "add %s %s -exist\n", set.name, p)
+		}
+	}
+	return buf.Bytes()
+}
+
+// Скрипт для nft -f: множества <name>_v4 и <name>_v6 в таблице nftTable
+func encodeNft(data []PrefixRecord, setName string) []byte {
+	v4, v6 := splitByFamily(data)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "add table %s\n", nftTable)
+	for _, set := range []struct {
+		name     string
+		addrType string
+		prefixes []netip.Prefix
+	}{
+		{setName + "_v4", "ipv4_addr", v4},
+		{setName + "_v6", "ipv6_addr", v6},
+	} {
+		fmt.Fprintf(&buf, "add set %s %s { type %s; flags interval; auto-merge; }\n", nftTable, set.name, set.addrType)
+		fmt.Fprintf(&buf, "flush set %s %s\n", nftTable, set.name)
+		if len(set.prefixes) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(&buf, "add element %s %s {", nftTable, set.name)
+		for i, p := range set.prefixes {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			fmt.Fprintf(&buf, "\n\t%s", p)
+		}
+		buf.WriteString("\n}\n")
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,202 @@
+package asnprefix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Офлайн-режим -fixtures: ответы dig, whois и API берутся из файлов директории,
+// а не из сети. Файлы разложены по видам запросов и названы по их аргументу:
+//
+//	dig/<имя>               вывод dig +noall +comments +answer <имя> A <имя> AAAA или dig +short
+//	dig/<имя>_MX, _NS       вывод dig +short <имя> MX (NS) для -include-mx и -include-ns
+//	whois/<IP>              ответ whois для адреса (формат любого whois-сервера)
+//	whois/AS<номер>         строка Team Cymru "15133 | EDGECAST, US" для -asn-names
+//	prefixes/AS<номер>.json ответ API выбранного -prefix-source
+//	routing/<префикс>.json  ответ RIPEstat routing-status для -verify, "/" заменяется на "_"
+//
+// Имени без файла в dig не существует (NXDOMAIN), без файла MX или NS у него нет
+// таких записей; отсутствие остальных файлов — ошибка ErrNoFixture.
+type fixtureDir string
+
+// Подкаталоги директории с заготовленными ответами
+const (
+	fixtureDig      = "dig"
+	fixtureWhois    = "whois"
+	fixturePrefixes = "prefixes"
+	fixtureRouting  = "routing"
+)
+
+// Проверка директории -fixtures до начала обработки
+func validateFixtures(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open fixtures directory: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("fixtures path %s is not a directory", dir)
+	}
+	return nil
+}
+
+// Содержимое заготовленного ответа kind/name
+func (d fixtureDir) read(ctx context.Context, kind, name string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return nil, fmt.Errorf("invalid fixture name: %q", name)
+	}
+
+	path := filepath.Join(string(d), kind, name)
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNoFixture, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixture: %w", err)
+	}
+	return data, nil
+}
+
+// Резолв по заготовленному выводу dig с переходом по CNAME, как у getIPsByDig
+type fixtureResolver struct {
+	dir fixtureDir
+}
+
+func (r fixtureResolver) Resolve(ctx context.Context, domain string) ([]netip.Addr, error) {
+	name := strings.TrimSuffix(strings.ToLower(domain), ".")
+	for depth := 0; depth <= maxCNAMEDepth; depth++ {
+		data, err := r.dir.read(ctx, fixtureDig, name)
+		if errors.Is(err, ErrNoFixture) {
+			return nil, fmt.Errorf("failed to resolve %s: %w", domain, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true})
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		answer := parseDigOutput(string(data))
+		if len(answer.ips) > 0 {
+			addrs := make([]netip.Addr, 0, len(answer.ips))
+			for _, ip := range answer.ips {
+				if addr, err := netip.ParseAddr(ip); err == nil {
+					addrs = append(addrs, addr.Unmap())
+				}
+			}
+			return addrs, nil
+		}
+		if err := answer.err(name, ""); err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %w", domain, err)
+		}
+		if len(answer.cnames) == 0 {
+			return nil, nil
+		}
+		name = strings.ToLower(answer.cnames[len(answer.cnames)-1])
+	}
+	return nil, fmt.Errorf("CNAME chain for %s is longer than %d", domain, maxCNAMEDepth)
+}
+
+func (r fixtureResolver) LookupHosts(ctx context.Context, domain, recordType string) ([]string, error) {
+	return r.dir.hosts(ctx, domain, recordType)
+}
+
+// Имена из заготовленных записей MX или NS домена
+func (d fixtureDir) hosts(ctx context.Context, domain, recordType string) ([]string, error) {
+	name := strings.TrimSuffix(strings.ToLower(domain), ".")
+	data, err := d.read(ctx, fixtureDig, name+"_"+recordType)
+	if errors.Is(err, ErrNoFixture) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseDigHosts(string(data)), nil
+}
+
+// Номера AS адреса из заготовленного ответа whois
+func (d fixtureDir) asNumbers(ctx context.Context, ip string, re *regexp.Regexp) ([]int, error) {
+	data, err := d.read(ctx, fixtureWhois, ip)
+	if err != nil {
+		return nil, err
+	}
+	if asNumbers := parseASNumbers(string(data), re); len(asNumbers) > 0 {
+		return asNumbers, nil
+	}
+	return nil, ErrASNNotFound
+}
+
+// Названия AS из заготовленных ответов Team Cymru; AS без файла пропускаются
+func (d fixtureDir) asNames(ctx context.Context, asNumbers []int) (map[int]string, error) {
+	names := make(map[int]string)
+	for _, asNumber := range asNumbers {
+		data, err := d.read(ctx, fixtureWhois, "AS"+strconv.Itoa(asNumber))
+		if errors.Is(err, ErrNoFixture) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range parseCymruTable(string(data)) {
+			if row.ASName != "" {
+				names[row.ASNs[0]] = row.ASName
+			}
+		}
+	}
+
+	if len(names) == 0 && len(asNumbers) > 0 {
+		return nil, fmt.Errorf("%w: no AS names in %s", ErrNoFixture, filepath.Join(string(d), fixtureWhois))
+	}
+	return names, nil
+}
+
+// AS, анонсирующие префикс, из заготовленного ответа routing-status
+func (d fixtureDir) routingOrigins(ctx context.Context, prefix string) ([]int, error) {
+	data, err := d.read(ctx, fixtureRouting, strings.ReplaceAll(prefix, "/", "_")+".json")
+	if err != nil {
+		return nil, err
+	}
+	var r routingStatusResponse
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON: %w", err)
+	}
+	return r.origins(prefix)
+}
+
+// Источник префиксов из заготовленных ответов API в формате source
+type fixtureProvider struct {
+	dir    fixtureDir
+	source string
+}
+
+func (p fixtureProvider) Prefixes(ctx context.Context, asNumber int) ([]Prefix, error) {
+	data, err := p.dir.read(ctx, fixturePrefixes, fmt.Sprintf("AS%d.json", asNumber))
+	if err != nil {
+		return nil, err
+	}
+
+	switch p.source {
+	case PrefixSourceRIPEstat:
+		var r ripestatResponse
+		if err := json.Unmarshal(data, &r); err != nil {
+			return nil, fmt.Errorf("failed to parse JSON: %w", err)
+		}
+		return r.prefixes(asNumber)
+	default:
+		var r heResponse
+		if err := json.Unmarshal(data, &r); err != nil {
+			return nil, fmt.Errorf("failed to parse JSON: %w", err)
+		}
+		return r.prefixes(asNumber)
+	}
+}
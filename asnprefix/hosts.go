@@ -0,0 +1,153 @@
+package asnprefix
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+)
+
+// Типы записей, из которых получен адрес домена
+const (
+	RecordA  = "A"  // Собственные адреса домена (A и AAAA)
+	RecordMX = "MX" // Адреса почтовых серверов домена
+	RecordNS = "NS" // Адреса DNS-серверов домена
+)
+
+// Адрес для поиска AS вместе с типом записи, из которой он получен
+type hostTarget struct {
+	ip         string
+	recordType string
+}
+
+// Получение имён из записей MX или NS командой dig (без завершающей точки)
+func getHostsByDig(ctx context.Context, domain, recordType, server string) ([]string, error) {
+	var args []string
+	if server != "" {
+		host, port, _ := net.SplitHostPort(server)
+		args = []string{"@" + host, "-p", port}
+	}
+	args = append(args, "+short", domain, recordType)
+
+	cmd := execCommand(ctx, "dig", args...)
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, commandError("dig", err, stderr.String()+"\n"+out.String())
+	}
+	return parseDigHosts(out.String()), nil
+}
+
+// Разбор вывода dig +short для записей MX или NS
+func parseDigHosts(output string) []string {
+	var hosts []string
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], ";") {
+			continue
+		}
+		// Строка MX имеет вид "10 mx.example.com.", строка NS — "ns1.example.com."
+		host := strings.TrimSuffix(fields[len(fields)-1], ".")
+		if host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// Получение имён из записей MX или NS встроенным резолвером Go
+func getHostsNative(ctx context.Context, resolver *net.Resolver, domain, recordType string) ([]string, error) {
+	var hosts []string
+	switch recordType {
+	case RecordMX:
+		records, err := resolver.LookupMX(ctx, domain)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up MX for %s: %w", domain, err)
+		}
+		for _, mx := range records {
+			hosts = append(hosts, strings.TrimSuffix(mx.Host, "."))
+		}
+	case RecordNS:
+		records, err := resolver.LookupNS(ctx, domain)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up NS for %s: %w", domain, err)
+		}
+		for _, ns := range records {
+			hosts = append(hosts, strings.TrimSuffix(ns.Host, "."))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported record type: %s", recordType)
+	}
+	return hosts, nil
+}
+
+// Получение имён из записей MX или NS домена резолвером из cfg.DNSResolver, а без
+// него — выбранным способом (dig, native или doh). Резолвер без HostResolver
+// записей MX и NS не даёт.
+func lookupHosts(ctx context.Context, domain, recordType string, cfg Config) ([]string, error) {
+	resolver := cfg.DNSResolver
+	if resolver == nil {
+		var err error
+		if resolver, err = newResolver(cfg); err != nil {
+			return nil, err
+		}
+	}
+	hostResolver, ok := resolver.(HostResolver)
+	if !ok {
+		return nil, nil
+	}
+
+	ctx, cancel := withTimeout(ctx, cfg.DNSTimeout)
+	defer cancel()
+
+	start := time.Now()
+	defer cfg.latency.observe(sourceDNS, start)
+	return hostResolver.LookupHosts(ctx, domain, recordType)
+}
+
+// Типы дополнительных записей, включённые флагами -include-mx и -include-ns
+func extraRecordTypes(cfg Config) []string {
+	var types []string
+	if cfg.IncludeMX {
+		types = append(types, RecordMX)
+	}
+	if cfg.IncludeNS {
+		types = append(types, RecordNS)
+	}
+	return types
+}
+
+// Адреса серверов из записей MX и NS домена. Ошибки отдельных записей и
+// серверов не прерывают обработку домена: они только записываются в лог.
+func resolveExtraTargets(ctx context.Context, domain string, cfg Config) []hostTarget {
+	var targets []hostTarget
+	seen := make(map[hostTarget]bool)
+	for _, recordType := range extraRecordTypes(cfg) {
+		hosts, err := lookupHosts(ctx, domain, recordType, cfg)
+		if err != nil {
+			slog.Warn("Error looking up host records", "domain", domain, "type", recordType, "error", err)
+			continue
+		}
+		slog.Debug("Host records found", "domain", domain, "type", recordType, "hosts", hosts)
+
+		for _, host := range hosts {
+			ips, err := ResolveDomain(ctx, host, cfg)
+			if err != nil {
+				slog.Warn("Error resolving host", "domain", domain, "type", recordType, "host", host, "error", err)
+				continue
+			}
+			for _, ip := range ips {
+				target := hostTarget{ip: ip, recordType: recordType}
+				if !seen[target] {
+					seen[target] = true
+					targets = append(targets, target)
+				}
+			}
+		}
+	}
+	return targets
+}
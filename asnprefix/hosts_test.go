@@ -0,0 +1,84 @@
+package asnprefix
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+// StaticResolver с заранее заданными записями MX и NS ("домен тип" → имена)
+type staticHostResolver struct {
+	StaticResolver
+	hosts map[string][]string
+}
+
+func (r staticHostResolver) LookupHosts(ctx context.Context, domain, recordType string) ([]string, error) {
+	return r.hosts[domain+" "+recordType], nil
+}
+
+func TestLookupHostsStaticResolver(t *testing.T) {
+	cfg := Config{Resolver: ResolverDig, DNSResolver: StaticResolver{}}
+	hosts, err := lookupHosts(context.Background(), "example.com", RecordMX, cfg)
+	if err != nil || hosts != nil {
+		t.Errorf("lookupHosts without HostResolver = %v, %v; want nil, nil", hosts, err)
+	}
+
+	cfg.DNSResolver = staticHostResolver{hosts: map[string][]string{"example.com MX": {"mx.example.com"}}}
+	hosts, err = lookupHosts(context.Background(), "example.com", RecordMX, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"mx.example.com"}; !reflect.DeepEqual(hosts, want) {
+		t.Errorf("lookupHosts = %v, want %v", hosts, want)
+	}
+}
+
+func TestResolveExtraTargetsStaticResolver(t *testing.T) {
+	cfg := Config{
+		Resolver:  ResolverDig,
+		IncludeMX: true,
+		IncludeNS: true,
+		DNSResolver: staticHostResolver{
+			StaticResolver: StaticResolver{
+				"mx.example.com":  {netip.MustParseAddr("93.184.215.25")},
+				"ns1.example.com": {netip.MustParseAddr("93.184.215.53"), netip.MustParseAddr("2606:2800::53")},
+			},
+			hosts: map[string][]string{
+				"example.com MX": {"mx.example.com", "missing.example.com"},
+				"example.com NS": {"ns1.example.com"},
+			},
+		},
+	}
+	got := resolveExtraTargets(context.Background(), "example.com", cfg)
+	want := []hostTarget{
+		{ip: "93.184.215.25", recordType: RecordMX},
+		{ip: "93.184.215.53", recordType: RecordNS},
+		{ip: "2606:2800::53", recordType: RecordNS},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("resolveExtraTargets = %v, want %v", got, want)
+	}
+}
+
+func TestCheckToolsWithDNSResolver(t *testing.T) {
+	saved := lookPath
+	defer func() { lookPath = saved }()
+	lookPath = func(file string) (string, error) {
+		return "", errors.New("not found")
+	}
+
+	cfg := Config{Resolver: ResolverDig, Whois: WhoisNative}
+	if err := checkTools(cfg); err == nil {
+		t.Error("checkTools did not require dig")
+	}
+	cfg.DNSResolver = StaticResolver{}
+	if err := checkTools(cfg); err != nil {
+		t.Errorf("checkTools with DNSResolver: %v", err)
+	}
+	cfg.Whois = WhoisCommand
+	if err := checkTools(cfg); err == nil {
+		t.Error("checkTools did not require whois")
+	}
+}
@@ -0,0 +1,52 @@
+package asnprefix
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// Преобразование интернационализированных доменов (IDN) в ASCII-форму punycode
+// и обратно по профилю Lookup (UTS #46): метки приводятся к нижнему регистру и
+// нормализуются так же, как это делают браузеры и резолверы.
+
+// Префикс ASCII-меток, закодированных punycode
+const acePrefix = "xn--"
+
+// ASCII-форма домена: метки с не-ASCII символами кодируются как xn--...
+// Имена только из ASCII не меняются, чтобы не отвергать допустимые для DNS
+// метки вроде _dmarc, которые запрещает профиль Lookup.
+func toASCII(domain string) (string, error) {
+	if isASCII(domain) {
+		return domain, nil
+	}
+	ascii, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode IDN: %w", err)
+	}
+	return ascii, nil
+}
+
+// Юникод-форма домена для записей результата; пусто, если домен не IDN
+// или его метки xn--... не декодируются
+func idnForm(domain string) string {
+	if !strings.Contains(domain, acePrefix) {
+		return ""
+	}
+	unicode, err := idna.Lookup.ToUnicode(domain)
+	if err != nil || unicode == domain {
+		return ""
+	}
+	return unicode
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
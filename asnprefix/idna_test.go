@@ -0,0 +1,59 @@
+package asnprefix
+
+import "testing"
+
+func TestToASCII(t *testing.T) {
+	tests := []struct {
+		domain string
+		want   string
+	}{
+		{"ПРИМЕР.рф", "xn--e1afmkfd.xn--p1ai"},
+		{"пример.рф", "xn--e1afmkfd.xn--p1ai"},
+		{"Bücher.example", "xn--bcher-kva.example"},
+		{"mail.ПримеР.рф", "mail.xn--e1afmkfd.xn--p1ai"},
+		{"example.com", "example.com"},
+		{"_dmarc.example.com", "_dmarc.example.com"},
+		{"xn--e1afmkfd.xn--p1ai", "xn--e1afmkfd.xn--p1ai"},
+	}
+	for _, tt := range tests {
+		got, err := toASCII(tt.domain)
+		if err != nil {
+			t.Errorf("toASCII(%q): %v", tt.domain, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("toASCII(%q) = %q, want %q", tt.domain, got, tt.want)
+		}
+	}
+
+	if _, err := toASCII("пример\u202e.рф"); err == nil {
+		t.Error("toASCII accepted a disallowed code point")
+	}
+}
+
+func TestIDNForm(t *testing.T) {
+	tests := []struct {
+		domain string
+		want   string
+	}{
+		{"xn--e1afmkfd.xn--p1ai", "пример.рф"},
+		{"www.xn--bcher-kva.example", "www.bücher.example"},
+		{"example.com", ""},
+		{"xn--99999999.example", ""},
+	}
+	for _, tt := range tests {
+		if got := idnForm(tt.domain); got != tt.want {
+			t.Errorf("idnForm(%q) = %q, want %q", tt.domain, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeDomainIDN(t *testing.T) {
+	got, err := normalizeDomain("https://ПРИМЕР.рф:443/path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "xn--e1afmkfd.xn--p1ai"; got != want {
+		t.Errorf("normalizeDomain = %q, want %q", got, want)
+	}
+}
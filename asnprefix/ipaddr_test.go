@@ -0,0 +1,112 @@
+package asnprefix
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.215.14", true},
+		{"8.8.8.8", true},
+		{"2606:2800:21f:cb07:6820:80da:af6b:8b2c", true},
+		{"2a00:1450:4001:80b::200e", true},
+		{"::ffff:93.184.215.14", true},
+		{" 93.184.215.14 ", true},
+
+		// RFC 1918
+		{"10.0.0.1", false},
+		{"10.255.255.255", false},
+		{"172.16.5.4", false},
+		{"192.168.1.1", false},
+		// Loopback
+		{"127.0.0.1", false},
+		{"127.10.20.30", false},
+		{"::1", false},
+		// Link-local
+		{"169.254.0.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		// Уникальные локальные адреса fc00::/7
+		{"fc00::1", false},
+		{"fd12:3456:789a::1", false},
+		{"fdff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", false},
+		// Соседние с fc00::/7 адреса в него не входят
+		{"fbff:ffff::1", true},
+		{"fe00::1", true},
+		// Отображённый в IPv6 частный адрес
+		{"::ffff:10.0.0.1", false},
+		// Зарезервированные и служебные диапазоны
+		{"0.0.0.0", false},
+		{"::", false},
+		{"100.64.0.1", false},
+		{"192.0.2.1", false},
+		{"198.18.0.1", false},
+		{"203.0.113.7", false},
+		{"240.0.0.1", false},
+		{"224.0.0.251", false},
+		{"ff02::fb", false},
+		{"2001:db8::1", false},
+		// Не адрес
+		{"", false},
+		{"example.com", false},
+		{"10.0.0.256", false},
+	}
+	for _, tt := range tests {
+		if got := isPublicIP(tt.ip); got != tt.want {
+			t.Errorf("isPublicIP(%q) = %t, want %t", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestResolveDomainSkipsNonPublicIPs(t *testing.T) {
+	cfg := Config{
+		Resolver: ResolverDig,
+		DNSResolver: StaticResolver{
+			"mixed.example": {
+				netip.MustParseAddr("10.1.2.3"),
+				netip.MustParseAddr("93.184.215.14"),
+				netip.MustParseAddr("fd00::1"),
+				netip.MustParseAddr("2606:2800::1"),
+			},
+			"internal.example": {
+				netip.MustParseAddr("127.0.0.1"),
+				netip.MustParseAddr("169.254.1.1"),
+				netip.MustParseAddr("fc00::2"),
+			},
+		},
+	}
+
+	logs := captureLog(t)
+	ips, err := ResolveDomain(context.Background(), "mixed.example", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"93.184.215.14", "2606:2800::1"}; !reflect.DeepEqual(ips, want) {
+		t.Errorf("ResolveDomain = %v, want %v", ips, want)
+	}
+	if out := logs.String(); !strings.Contains(out, "Skipping non-public IP") || !strings.Contains(out, "ip=10.1.2.3") || !strings.Contains(out, "ip=fd00::1") {
+		t.Errorf("no non-public IP messages in log:\n%s", out)
+	}
+
+	if _, err := ResolveDomain(context.Background(), "internal.example", cfg); !errors.Is(err, ErrNoPublicIPs) {
+		t.Errorf("ResolveDomain of a domain with only private IPs: err = %v, want ErrNoPublicIPs", err)
+	}
+
+	// С -include-private частные адреса обрабатываются как обычные
+	cfg.IncludePrivate = true
+	ips, err = ResolveDomain(context.Background(), "internal.example", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"127.0.0.1", "fc00::2"}; !reflect.DeepEqual(ips, want) {
+		t.Errorf("ResolveDomain with IncludePrivate = %v, want %v", ips, want)
+	}
+}
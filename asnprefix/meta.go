@@ -0,0 +1,158 @@
+package asnprefix
+
+import (
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// Сведения о сборке, записываемые в meta и выводимые по -version; задаются при
+// сборке через -ldflags "-X maskSites/asnprefix.Version=... -X maskSites/asnprefix.Commit=...
+// -X maskSites/asnprefix.BuildDate=...". Незаданные значения берутся из сведений
+// о сборке Go (версия модуля и данные системы контроля версий).
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// BuildInfo описывает сборку утилиты
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	Modified  bool   `json:"modified,omitempty"` // Сборка из рабочей копии с незафиксированными изменениями
+	GoVersion string `json:"go_version"`
+}
+
+// ReadBuildInfo возвращает сведения о сборке: значения из -ldflags, а при их
+// отсутствии — из runtime/debug.ReadBuildInfo
+func ReadBuildInfo() BuildInfo {
+	info := BuildInfo{Version: Version, Commit: Commit, BuildDate: BuildDate, GoVersion: runtime.Version()}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+
+	if info.Version == "dev" && build.Main.Version != "" && build.Main.Version != "(devel)" {
+		info.Version = build.Main.Version
+	}
+	// Коммит из -ldflags не сопоставить с состоянием рабочей копии, поэтому
+	// признак изменений берётся только вместе с коммитом из VCS
+	vcsCommit := info.Commit == ""
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if vcsCommit {
+				info.Commit = setting.Value
+			}
+		case "vcs.time":
+			if info.BuildDate == "" {
+				info.BuildDate = setting.Value
+			}
+		case "vcs.modified":
+			info.Modified = vcsCommit && setting.Value == "true"
+		}
+	}
+	return info
+}
+
+// String — строка для вывода по -version
+func (b BuildInfo) String() string {
+	s := "maskSites " + b.Version
+	if b.Commit != "" {
+		commit := b.Commit
+		if len(commit) > 12 {
+			commit = commit[:12]
+		}
+		if b.Modified {
+			commit += "-dirty"
+		}
+		s += " (commit " + commit + ")"
+	}
+	if b.BuildDate != "" {
+		s += ", built " + b.BuildDate
+	}
+	return s + ", " + b.GoVersion
+}
+
+// Выходной JSON-документ: сведения о запуске и собранные записи
+type outputDocument struct {
+	Meta    outputMeta     `json:"meta"`
+	Results []PrefixRecord `json:"results"`
+}
+
+// Сведения о запуске для самодокументируемого результата
+type outputMeta struct {
+	GeneratedAt time.Time   `json:"generated_at"`
+	Version     string      `json:"version"`
+	Build       BuildInfo   `json:"build"`
+	Records     int         `json:"records"`
+	Options     metaOptions `json:"options"`
+}
+
+// Настройки, влияющие на состав результата
+type metaOptions struct {
+	Input           string   `json:"input,omitempty"`
+	Domains         []string `json:"domains,omitempty"`
+	ASNs            []int    `json:"asns,omitempty"`
+	Resolver        string   `json:"resolver"`
+	DNSServer       string   `json:"dns_server,omitempty"`
+	DoHURL          string   `json:"doh_url,omitempty"`
+	Whois           string   `json:"whois"`
+	ASNMap          string   `json:"asn_map,omitempty"`
+	Fixtures        string   `json:"fixtures,omitempty"`
+	PrefixSource    string   `json:"prefix_source"`
+	AllowASNs       []int    `json:"allow_asns,omitempty"`
+	DenyASNs        []int    `json:"deny_asns,omitempty"`
+	AllowPrivateASN bool     `json:"allow_private_asn,omitempty"`
+	Dedup           bool     `json:"dedup"`
+	Aggregate       bool     `json:"aggregate"`
+	Family          string   `json:"family"`
+	ExcludeCIDRs    []string `json:"exclude_cidrs,omitempty"`
+	ExcludeOverlap  bool     `json:"exclude_overlap,omitempty"`
+	Verify          bool     `json:"verify"`
+	Sort            bool     `json:"sort"`
+	ResolveAllIPs   bool     `json:"resolve_all_ips"`
+	IncludePrivate  bool     `json:"include_private"`
+	IncludeMX       bool     `json:"include_mx"`
+	IncludeNS       bool     `json:"include_ns"`
+	IncludeFailed   bool     `json:"include_failed"`
+}
+
+func newOutputMeta(cfg Config, records int) outputMeta {
+	build := ReadBuildInfo()
+	return outputMeta{
+		GeneratedAt: time.Now().UTC().Truncate(time.Second),
+		Version:     build.Version,
+		Build:       build,
+		Records:     records,
+		Options: metaOptions{
+			Input:           cfg.Input,
+			Domains:         cfg.Domains,
+			ASNs:            cfg.ASNs,
+			Resolver:        cfg.Resolver,
+			DNSServer:       cfg.DNSServer,
+			DoHURL:          dohURL(cfg),
+			Whois:           cfg.Whois,
+			ASNMap:          cfg.ASNMap,
+			Fixtures:        cfg.Fixtures,
+			PrefixSource:    cfg.PrefixSource,
+			AllowASNs:       cfg.AllowASNs,
+			DenyASNs:        cfg.DenyASNs,
+			AllowPrivateASN: cfg.AllowPrivateASN,
+			Dedup:           cfg.Dedup,
+			Aggregate:       cfg.Aggregate,
+			Family:          cfg.Family,
+			ExcludeCIDRs:    prefixStrings(cfg.ExcludeCIDRs),
+			ExcludeOverlap:  cfg.ExcludeOverlap,
+			Verify:          cfg.Verify,
+			Sort:            cfg.Sort,
+			ResolveAllIPs:   cfg.ResolveAllIPs,
+			IncludePrivate:  cfg.IncludePrivate,
+			IncludeMX:       cfg.IncludeMX,
+			IncludeNS:       cfg.IncludeNS,
+			IncludeFailed:   cfg.IncludeFailed,
+		},
+	}
+}
@@ -0,0 +1,108 @@
+package asnprefix
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Источники запросов, для которых собирается задержка
+const (
+	sourceDNS      = "dns"
+	sourceWhois    = "whois"
+	sourcePrefixes = "prefixes"
+	sourceVerify   = "verify"
+)
+
+// LatencyStat — число запросов к источнику и их суммарная длительность
+type LatencyStat struct {
+	Count int64
+	Total time.Duration
+}
+
+// Сбор задержек запросов по источникам, общий для всех воркеров; nil ничего не собирает
+type latencyRecorder struct {
+	mu    sync.Mutex
+	stats map[string]LatencyStat
+}
+
+func newLatencyRecorder() *latencyRecorder {
+	return &latencyRecorder{stats: make(map[string]LatencyStat)}
+}
+
+// Учёт запроса к источнику, начатого в start
+func (r *latencyRecorder) observe(source string, start time.Time) {
+	if r == nil {
+		return
+	}
+	elapsed := time.Since(start)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stat := r.stats[source]
+	stat.Count++
+	stat.Total += elapsed
+	r.stats[source] = stat
+}
+
+// Копия собранных задержек
+func (r *latencyRecorder) snapshot() map[string]LatencyStat {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := make(map[string]LatencyStat, len(r.stats))
+	for source, stat := range r.stats {
+		stats[source] = stat
+	}
+	return stats
+}
+
+// WriteMetrics выводит метрики запуска в текстовом формате Prometheus
+func WriteMetrics(w io.Writer, result *Result) error {
+	var buf bytes.Buffer
+	gauge := func(name, help string, value interface{}) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", name, help, name, name, value)
+	}
+
+	gauge("asnprefix_domains", "Domains processed in the last run.", len(result.Domains))
+	gauge("asnprefix_domains_failed", "Domains for which no prefixes were collected.", result.Failed())
+	gauge("asnprefix_prefixes", "Prefixes written by the last run.", len(result.Records))
+	gauge("asnprefix_run_duration_seconds", "Duration of the last run.", result.Duration.Seconds())
+	gauge("asnprefix_last_run_timestamp_seconds", "Unix time the last run finished.", time.Now().Unix())
+
+	sources := make([]string, 0, len(result.Latency))
+	for source := range result.Latency {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	const latency = "asnprefix_request_duration_seconds"
+	fmt.Fprintf(&buf, "# HELP %s Time spent in lookups, by source.\n# TYPE %s summary\n", latency, latency)
+	for _, source := range sources {
+		stat := result.Latency[source]
+		fmt.Fprintf(&buf, "%s_sum{source=%q} %v\n", latency, source, stat.Total.Seconds())
+		fmt.Fprintf(&buf, "%s_count{source=%q} %d\n", latency, source, stat.Count)
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// SaveMetrics сохраняет метрики в файл для textfile collector node_exporter.
+// Файл заменяется целиком, чтобы сборщик не прочитал его наполовину.
+func SaveMetrics(filename string, result *Result) error {
+	var buf bytes.Buffer
+	if err := WriteMetrics(&buf, result); err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(filename, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package asnprefix
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	result := &Result{
+		Domains:  []string{"example.com", "gone.example"},
+		Errors:   []error{nil, errors.New("no such host")},
+		Records:  []PrefixRecord{{Prefix: "93.184.215.0/24"}, {Prefix: "2606:2800::/32"}},
+		Duration: 1500 * time.Millisecond,
+		Latency:  map[string]LatencyStat{sourceDNS: {Count: 2, Total: 300 * time.Millisecond}},
+	}
+	var buf bytes.Buffer
+	if err := WriteMetrics(&buf, result); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		"# TYPE asnprefix_domains gauge\nasnprefix_domains 2\n",
+		"# TYPE asnprefix_domains_failed gauge\nasnprefix_domains_failed 1\n",
+		"# TYPE asnprefix_prefixes gauge\nasnprefix_prefixes 2\n",
+		"asnprefix_run_duration_seconds 1.5\n",
+		`asnprefix_request_duration_seconds_sum{source="dns"} 0.3` + "\n",
+		`asnprefix_request_duration_seconds_count{source="dns"} 2` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics do not contain %q:\n%s", want, out)
+		}
+	}
+
+	// Суффикс _total по соглашениям Prometheus зарезервирован для счётчиков
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "# TYPE ") && strings.HasSuffix(line, " gauge") && strings.Contains(line, "_total ") {
+			t.Errorf("gauge with a _total suffix: %s", line)
+		}
+	}
+}
@@ -0,0 +1,494 @@
+package asnprefix
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Структура для сохранения префиксов в файл в старом формате (-legacy-format)
+type PrefixForFile struct {
+	Hostname string `json:"hostname"`
+	IP       string `json:"ip"`
+}
+
+// Запись о префиксе вместе с его происхождением: домен, IP-адрес и AS
+type PrefixRecord struct {
+	Domain     string   `json:"domain"`
+	IDN        string   `json:"idn,omitempty"`         // Исходная юникод-форма домена, если Domain записан в punycode
+	Domains    []string `json:"domains,omitempty"`     // Все домены, давшие префикс (заполняется при дедупликации)
+	IP         string   `json:"ip"`                    // Адрес, по которому найдена AS; при нескольких — первый из них
+	IPs        []string `json:"ips,omitempty"`         // Все адреса, давшие префикс, если их больше одного
+	RecordType string   `json:"record_type,omitempty"` // Тип записи (A, MX, NS), давшей адрес; заполняется с -include-mx или -include-ns
+	ASN        int      `json:"asn"`
+	ASName     string   `json:"as_name,omitempty"` // Название AS (заполняется с -asn-names)
+	Tags       []string `json:"tags,omitempty"`    // Метки "ключ:значение" доменов из комментариев входного файла
+	Prefix     string   `json:"prefix"`
+	Error      string   `json:"error,omitempty"` // Причина неудачи домена; у такой записи нет префикса
+}
+
+// Адреса записи: список IPs, а при единственном адресе — IP
+func (r PrefixRecord) addresses() []string {
+	if len(r.IPs) > 0 {
+		return r.IPs
+	}
+	if r.IP != "" {
+		return []string{r.IP}
+	}
+	return nil
+}
+
+// Добавление адресов записи r к адресам entry; список IPs заводится,
+// только если адресов стало больше одного
+func mergeIPs(entry *PrefixRecord, r PrefixRecord) {
+	union := append([]string(nil), entry.addresses()...)
+	for _, ip := range r.addresses() {
+		union = appendUnique(union, ip)
+	}
+	if len(union) > 1 {
+		entry.IPs = union
+	}
+}
+
+// CIDR возвращает префикс записи как netip.Prefix с обнулёнными битами хоста
+func (r PrefixRecord) CIDR() (netip.Prefix, error) {
+	return parseCIDR(r.Prefix)
+}
+
+// Преобразование записей в старый формат: префикс в поле hostname, адрес в поле ip
+func toLegacyFormat(data []PrefixRecord) []PrefixForFile {
+	legacy := make([]PrefixForFile, 0, len(data))
+	for _, r := range data {
+		if r.Error != "" {
+			continue // В старом формате нет поля для ошибки
+		}
+		legacy = append(legacy, PrefixForFile{
+			Hostname: r.Prefix,
+			IP:       r.IP,
+		})
+	}
+	return legacy
+}
+
+// Форматы выходного файла
+const (
+	FormatJSON   = "json"
+	FormatCSV    = "csv"
+	FormatPlain  = "plain"
+	FormatIPSet  = "ipset"
+	FormatNft    = "nft"
+	FormatNDJSON = "ndjson"
+	FormatTSV    = "tsv"
+
+	FormatTemplate = "template" // Вывод по шаблону -template
+)
+
+// Сериализация префиксов в выбранный в cfg формат
+func encodePrefixes(data []PrefixRecord, cfg Config) ([]byte, error) {
+	switch cfg.Format {
+	case FormatJSON:
+		return encodeJSON(data, cfg)
+	case FormatCSV:
+		return encodeCSV(data, cfg, ',')
+	case FormatTSV:
+		return encodeCSV(data, cfg, '\t')
+	case FormatNDJSON:
+		return encodeNDJSON(data)
+	case FormatPlain:
+		return encodePlain(data), nil
+	case FormatIPSet:
+		return encodeIPSet(data, cfg.SetName), nil
+	case FormatNft:
+		return encodeNft(data, cfg.SetName), nil
+	case FormatTemplate:
+		t, err := newOutputTemplate(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return t.encode(data)
+	default:
+		return nil, fmt.Errorf("unknown output format: %s", cfg.Format)
+	}
+}
+
+// JSON-документ с разделами meta и results; с cfg.Flat — массив записей,
+// а в старом формате — массив объектов {hostname, ip}
+func encodeJSON(data []PrefixRecord, cfg Config) ([]byte, error) {
+	var v interface{}
+	switch {
+	case cfg.LegacyFormat:
+		v = toLegacyFormat(data)
+	case cfg.Flat:
+		v = data
+	default:
+		if data == nil {
+			data = []PrefixRecord{}
+		}
+		v = outputDocument{Meta: newOutputMeta(cfg, len(data)), Results: data}
+	}
+	jsonData, err := marshalJSON(v, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	return jsonData, nil
+}
+
+// JSON с отступами или, с cfg.JSONCompact, одной строкой
+func marshalJSON(v interface{}, cfg Config) ([]byte, error) {
+	if cfg.JSONCompact {
+		return json.Marshal(v)
+	}
+	return json.MarshalIndent(v, "", "    ")
+}
+
+// JSON-объект на каждую запись, по одному на строке
+func encodeNDJSON(data []PrefixRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range data {
+		if err := enc.Encode(r); err != nil {
+			return nil, fmt.Errorf("failed to marshal JSON: %w", err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// CSV (или TSV при comma == '\t') с колонками domain,asn,prefix; с названиями AS
+// добавляется колонка as_name, с MX или NS — record_type, с метками доменов — tags
+// (через ";"), с записями о неудачных доменах — колонка error
+func encodeCSV(data []PrefixRecord, cfg Config, comma rune) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Comma = comma
+	header := []string{"domain", "asn", "prefix", "ip"}
+	if cfg.ASNames {
+		header = append(header, "as_name")
+	}
+	recordTypes := len(extraRecordTypes(cfg)) > 0
+	if recordTypes {
+		header = append(header, "record_type")
+	}
+	tagged := false
+	for _, p := range data {
+		if len(p.Tags) > 0 {
+			tagged = true
+			break
+		}
+	}
+	if tagged {
+		header = append(header, "tags")
+	}
+	if cfg.IncludeFailed {
+		header = append(header, "error")
+	}
+	if err := w.Write(header); err != nil {
+		return nil, fmt.Errorf("failed to write CSV: %w", err)
+	}
+	for _, p := range data {
+		// После дедупликации у префикса может быть несколько доменов
+		domain := p.Domain
+		if len(p.Domains) > 0 {
+			domain = strings.Join(p.Domains, ";")
+		}
+		row := []string{domain, strconv.Itoa(p.ASN), p.Prefix, strings.Join(p.addresses(), ";")}
+		if p.Error != "" {
+			row[1] = ""
+		}
+		if cfg.ASNames {
+			row = append(row, p.ASName)
+		}
+		if recordTypes {
+			row = append(row, p.RecordType)
+		}
+		if tagged {
+			row = append(row, strings.Join(p.Tags, ";"))
+		}
+		if cfg.IncludeFailed {
+			row = append(row, p.Error)
+		}
+		if err := w.Write(row); err != nil {
+			return nil, fmt.Errorf("failed to write CSV: %w", err)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, fmt.Errorf("failed to write CSV: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// По одному префиксу на строке
+func encodePlain(data []PrefixRecord) []byte {
+	var buf bytes.Buffer
+	for _, p := range data {
+		if p.Prefix == "" {
+			continue
+		}
+		buf.WriteString(p.Prefix)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// Записи с префиксами выбранного семейства адресов; записи об ошибках сохраняются
+func filterFamily(records []PrefixRecord, family string) []PrefixRecord {
+	if family == FamilyBoth || family == "" {
+		return records
+	}
+	filtered := records[:0:0]
+	for _, r := range records {
+		p, err := r.CIDR()
+		if err != nil {
+			if r.Prefix == "" {
+				filtered = append(filtered, r)
+			}
+			continue
+		}
+		if p.Addr().Is4() == (family == FamilyIPv4) {
+			filtered = append(filtered, r)
+		}
+	}
+	return filtered
+}
+
+// Сортировка записей по префиксу: по адресу (IPv4 раньше IPv6), затем по длине
+// маски; записи без корректного префикса идут в конце в исходном порядке
+func sortRecords(records []PrefixRecord) {
+	keys := make([]netip.Prefix, len(records))
+	for i, r := range records {
+		keys[i], _ = r.CIDR()
+	}
+	sort.Stable(recordsByPrefix{records, keys})
+}
+
+// Записи вместе с разобранными префиксами для sort.Stable
+type recordsByPrefix struct {
+	records []PrefixRecord
+	keys    []netip.Prefix
+}
+
+func (s recordsByPrefix) Len() int { return len(s.records) }
+
+func (s recordsByPrefix) Swap(i, j int) {
+	s.records[i], s.records[j] = s.records[j], s.records[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
+
+func (s recordsByPrefix) Less(i, j int) bool {
+	a, b := s.keys[i], s.keys[j]
+	if a.IsValid() != b.IsValid() {
+		return a.IsValid()
+	}
+	if c := a.Addr().Compare(b.Addr()); c != 0 {
+		return c < 0
+	}
+	return a.Bits() < b.Bits()
+}
+
+// Функция для сохранения префиксов в файл; имя "-" означает стандартный вывод
+func savePrefixesToFile(data []PrefixRecord, filename string, cfg Config) error {
+	// Сериализуем данные в выбранный формат
+	encoded, err := encodePrefixes(data, cfg)
+	if err != nil {
+		return err
+	}
+
+	if filename == "-" {
+		if _, err := os.Stdout.Write(encoded); err != nil {
+			return fmt.Errorf("failed to write to stdout: %w", err)
+		}
+		return nil
+	}
+
+	// Записываем данные в файл атомарно, чтобы читатели не увидели его недописанным
+	if err := writeFileAtomic(filename, encoded, outputMode(cfg)); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+
+	return nil
+}
+
+// Права выходного файла по умолчанию (до применения umask)
+const DefaultOutputMode os.FileMode = 0644
+
+// Права выходного файла из cfg; 0 — права по умолчанию
+func outputMode(cfg Config) os.FileMode {
+	if cfg.OutputMode == 0 {
+		return DefaultOutputMode
+	}
+	return cfg.OutputMode
+}
+
+// Создание временного файла рядом с filename с правами perm; как и при
+// обычном создании файла, права ограничиваются umask процесса
+func createTempFile(filename string, perm os.FileMode) (*os.File, error) {
+	dir, base := filepath.Dir(filename), filepath.Base(filename)
+	for i := 0; i < 100; i++ {
+		name := filepath.Join(dir, "."+base+"."+strconv.FormatUint(rand.Uint64(), 36)+".tmp")
+		file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if os.IsExist(err) {
+			continue
+		}
+		return file, err
+	}
+	return nil, fmt.Errorf("failed to create a temporary file next to %s", filename)
+}
+
+// Запись файла через временный файл в той же директории и переименование:
+// файл по пути filename либо остаётся прежним, либо содержит все данные
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	tmp, err := createTempFile(filename, perm)
+	if err != nil {
+		return err
+	}
+	// После успешного переименования удалять уже нечего
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// Содержимое файла домена в -output-dir
+type domainFile struct {
+	Domain   string   `json:"domain"`
+	IDN      string   `json:"idn,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	IPs      []string `json:"ips,omitempty"` // Адреса домена, по которым найдены AS
+	ASNs     []int    `json:"asns"`
+	Prefixes []string `json:"prefixes"`
+}
+
+// Имя файла домена: символы, недопустимые в именах файлов, заменяются на "_"
+func domainFileName(domain string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		}
+		return '_'
+	}, domain)
+	// Имя не должно быть скрытым файлом или ссылкой на директорию
+	if name == "" || strings.HasPrefix(name, ".") {
+		name = "_" + name
+	}
+	return name + ".json"
+}
+
+// Сохранение файла <домен>.json с AS и префиксами каждого успешного домена.
+// Файлы неудачных доменов не перезаписываются, чтобы сохранить прошлый результат.
+func saveDomainFiles(dir string, domains []string, perDomain [][]PrefixRecord, errs []error, cfg Config) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	for i, domain := range domains {
+		if errs[i] != nil {
+			continue
+		}
+		records := perDomain[i]
+		if cfg.Aggregate {
+			records = aggregateRecords(records)
+		}
+		records = filterOutput(records, cfg)
+		if cfg.Sort {
+			records = append([]PrefixRecord(nil), records...)
+			sortRecords(records)
+		}
+
+		file := domainFile{Domain: domain, IDN: idnForm(domain), Tags: cfg.domainTags[domain], ASNs: []int{}, Prefixes: []string{}}
+		for _, r := range records {
+			for _, ip := range r.addresses() {
+				file.IPs = appendUnique(file.IPs, ip)
+			}
+			if r.ASN != 0 && !containsInt(file.ASNs, r.ASN) {
+				file.ASNs = append(file.ASNs, r.ASN)
+			}
+			file.Prefixes = append(file.Prefixes, r.Prefix)
+		}
+
+		data, err := marshalJSON(file, cfg)
+		if err != nil {
+			return fmt.Errorf("failed to marshal JSON: %w", err)
+		}
+		path := filepath.Join(dir, domainFileName(domain))
+		if err := writeFileAtomic(path, data, outputMode(cfg)); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// Есть ли число в списке
+func containsInt(list []int, v int) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Записи об ошибках для доменов, по которым не получено префиксов
+func failedRecords(domains []string, errs []error) []PrefixRecord {
+	var records []PrefixRecord
+	for i, err := range errs {
+		if err != nil {
+			records = append(records, PrefixRecord{Domain: domains[i], IDN: idnForm(domains[i]), Error: err.Error()})
+		}
+	}
+	return records
+}
+
+// Объединение результатов всех доменов в порядке входного списка
+func flattenResults(perDomain [][]PrefixRecord) []PrefixRecord {
+	var results []PrefixRecord
+	for _, res := range perDomain {
+		results = append(results, res...)
+	}
+	return results
+}
+
+// Удаление повторяющихся префиксов с сохранением списка доменов, которые их дали.
+// Порядок определяется первым появлением префикса во входном списке доменов.
+func dedupPrefixes(domains []string, perDomain [][]PrefixRecord) []PrefixRecord {
+	var results []PrefixRecord
+	index := make(map[string]int)
+
+	for i, res := range perDomain {
+		for _, p := range res {
+			pos, ok := index[p.Prefix]
+			if !ok {
+				pos = len(results)
+				index[p.Prefix] = pos
+				results = append(results, p)
+				results[pos].Domains = nil
+			}
+
+			entry := &results[pos]
+			if n := len(entry.Domains); n == 0 || entry.Domains[n-1] != domains[i] {
+				entry.Domains = append(entry.Domains, domains[i])
+			}
+			entry.Tags = mergeTags(entry.Tags, p.Tags)
+			mergeIPs(entry, p)
+		}
+	}
+	return results
+}
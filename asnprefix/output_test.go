@@ -0,0 +1,194 @@
+package asnprefix
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// Префиксы записей в порядке следования
+func recordPrefixes(records []PrefixRecord) []string {
+	var prefixes []string
+	for _, r := range records {
+		prefixes = append(prefixes, r.Prefix)
+	}
+	return prefixes
+}
+
+func TestFilterFamily(t *testing.T) {
+	records := []PrefixRecord{
+		{Domain: "example.com", Prefix: "93.184.215.0/24"},
+		{Domain: "example.com", Prefix: "2606:2800::/32"},
+		{Domain: "gone.example", Error: "no such host"},
+		{Domain: "example.com", Prefix: "bogus"},
+		{Domain: "example.com", Prefix: "198.51.100.0/24"},
+	}
+	tests := []struct {
+		family string
+		want   []string
+	}{
+		// Записи об ошибках (без префикса) сохраняются в любом семействе
+		{FamilyIPv4, []string{"93.184.215.0/24", "", "198.51.100.0/24"}},
+		{FamilyIPv6, []string{"2606:2800::/32", ""}},
+		{FamilyBoth, []string{"93.184.215.0/24", "2606:2800::/32", "", "bogus", "198.51.100.0/24"}},
+		{"", []string{"93.184.215.0/24", "2606:2800::/32", "", "bogus", "198.51.100.0/24"}},
+	}
+	for _, tt := range tests {
+		if got := recordPrefixes(filterFamily(records, tt.family)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("filterFamily(%q) = %v, want %v", tt.family, got, tt.want)
+		}
+	}
+	if len(records) != 5 || records[1].Prefix != "2606:2800::/32" {
+		t.Error("filterFamily modified its input")
+	}
+}
+
+func TestFilterFamilyAfterAggregation(t *testing.T) {
+	// Фильтр применяется к сведённым префиксам: половины /25 объединяются в /24
+	records := aggregateRecords([]PrefixRecord{
+		{Domain: "example.com", Prefix: "93.184.215.0/25"},
+		{Domain: "example.com", Prefix: "93.184.215.128/25"},
+		{Domain: "example.com", Prefix: "2606:2800::/33"},
+		{Domain: "example.com", Prefix: "2606:2800:8000::/33"},
+	})
+	cfg := Config{Family: FamilyIPv6}
+	if got, want := recordPrefixes(filterOutput(records, cfg)), []string{"2606:2800::/32"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("IPv6 output = %v, want %v", got, want)
+	}
+	cfg.Family = FamilyIPv4
+	if got, want := recordPrefixes(filterOutput(records, cfg)), []string{"93.184.215.0/24"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("IPv4 output = %v, want %v", got, want)
+	}
+}
+
+// Записи с полным набором необязательных полей и запись об ошибке
+var goldenRecords = []PrefixRecord{
+	{
+		Domain:     "xn--e1afmkfd.xn--p1ai",
+		IDN:        "пример.рф",
+		Domains:    []string{"xn--e1afmkfd.xn--p1ai", "example.com"},
+		IP:         "93.184.215.14",
+		IPs:        []string{"93.184.215.14", "93.184.215.15"},
+		RecordType: RecordMX,
+		ASN:        15133,
+		ASName:     "EDGECAST",
+		Tags:       []string{"team:web"},
+		Prefix:     "93.184.215.0/24",
+	},
+	{Domain: "example.org", IP: "2606:2800::1", ASN: 15133, Prefix: "2606:2800::/32"},
+	{Domain: "gone.example", Error: "no such host"},
+}
+
+const goldenFlatJSON = `[
+    {
+        "domain": "xn--e1afmkfd.xn--p1ai",
+        "idn": "пример.рф",
+        "domains": [
+            "xn--e1afmkfd.xn--p1ai",
+            "example.com"
+        ],
+        "ip": "93.184.215.14",
+        "ips": [
+            "93.184.215.14",
+            "93.184.215.15"
+        ],
+        "record_type": "MX",
+        "asn": 15133,
+        "as_name": "EDGECAST",
+        "tags": [
+            "team:web"
+        ],
+        "prefix": "93.184.215.0/24"
+    },
+    {
+        "domain": "example.org",
+        "ip": "2606:2800::1",
+        "asn": 15133,
+        "prefix": "2606:2800::/32"
+    },
+    {
+        "domain": "gone.example",
+        "ip": "",
+        "asn": 0,
+        "prefix": "",
+        "error": "no such host"
+    }
+]`
+
+func TestEncodeJSONGolden(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want string
+	}{
+		{"flat", Config{Flat: true}, goldenFlatJSON},
+		{"compact", Config{Flat: true, JSONCompact: true}, `[{"domain":"example.org","ip":"2606:2800::1","asn":15133,"prefix":"2606:2800::/32"}]`},
+		// Старый формат пропускает записи об ошибках
+		{"legacy", Config{LegacyFormat: true}, `[
+    {
+        "hostname": "93.184.215.0/24",
+        "ip": "93.184.215.14"
+    },
+    {
+        "hostname": "2606:2800::/32",
+        "ip": "2606:2800::1"
+    }
+]`},
+	}
+	for _, tt := range tests {
+		records := goldenRecords
+		if tt.cfg.JSONCompact {
+			records = goldenRecords[1:2]
+		}
+		got, err := encodeJSON(records, tt.cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s JSON:\n%s\nwant:\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeJSONDocument(t *testing.T) {
+	got, err := encodeJSON(goldenRecords, Config{Resolver: ResolverDig, Whois: WhoisCommand, PrefixSource: PrefixSourceHE})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Meta    map[string]json.RawMessage `json:"meta"`
+		Results json.RawMessage            `json:"results"`
+	}
+	if err := json.Unmarshal(got, &doc); err != nil {
+		t.Fatalf("document is not valid JSON: %v", err)
+	}
+	for _, key := range []string{"generated_at", "version", "build", "records", "options"} {
+		if _, ok := doc.Meta[key]; !ok {
+			t.Errorf("meta has no %q", key)
+		}
+	}
+	if string(doc.Meta["records"]) != "3" {
+		t.Errorf("meta.records = %s, want 3", doc.Meta["records"])
+	}
+	// Раздел results совпадает с плоским выводом
+	var results, flat []PrefixRecord
+	if err := json.Unmarshal(doc.Results, &results); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(goldenFlatJSON), &flat); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(results, flat) {
+		t.Errorf("results = %+v, want %+v", results, flat)
+	}
+
+	// Пустой результат — пустой массив, а не null
+	got, err = encodeJSON(nil, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(got, []byte(`"results": []`)) {
+		t.Errorf("empty document has no empty results array:\n%s", got)
+	}
+}
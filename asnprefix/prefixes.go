@@ -0,0 +1,504 @@
+package asnprefix
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Структура для обработки префиксов
+type Prefix struct {
+	Prefix string `json:"Prefix"`
+	Count  int    `json:"Count"`
+	Total  int    `json:"Total"`
+}
+
+// CIDR возвращает префикс как netip.Prefix с обнулёнными битами хоста
+func (p Prefix) CIDR() (netip.Prefix, error) {
+	return parseCIDR(p.Prefix)
+}
+
+// Разбор CIDR-префикса с обнулением битов хоста
+func parseCIDR(s string) (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(strings.TrimSpace(s))
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid prefix %q: %w", s, err)
+	}
+	return prefix.Masked(), nil
+}
+
+// Отбрасывание некорректных префиксов из ответа API, чтобы они не попали в правила.
+// Корректные префиксы приводятся к каноническому виду (биты хоста обнуляются).
+func validPrefixes(asNumber int, prefixes []Prefix) []Prefix {
+	valid := prefixes[:0:0]
+	for _, prefix := range prefixes {
+		cidr, err := prefix.CIDR()
+		if err != nil {
+			slog.Warn("Skipping invalid prefix from API", "asn", asNumber, "prefix", prefix.Prefix, "error", err)
+			continue
+		}
+		if canonical := cidr.String(); canonical != prefix.Prefix {
+			slog.Warn("Normalized non-canonical prefix from API", "asn", asNumber, "prefix", prefix.Prefix, "normalized", canonical)
+			prefix.Prefix = canonical
+		}
+		valid = append(valid, prefix)
+	}
+	return valid
+}
+
+// Структура для общего ответа API
+type ApiResponse struct {
+	Prefixes []Prefix `json:"prefixes"`
+}
+
+// Ответ bgp.he.net для проверки схемы: отсутствующее поле prefixes
+// отличается от пустого списка
+type heResponse struct {
+	Prefixes *[]Prefix `json:"prefixes"`
+}
+
+// Проверка схемы ответа bgp.he.net
+func (r heResponse) validate() error {
+	if r.Prefixes == nil {
+		return fmt.Errorf("%w: missing \"prefixes\" field", ErrUnexpectedResponse)
+	}
+	for i, prefix := range *r.Prefixes {
+		if prefix.Prefix == "" {
+			return fmt.Errorf("%w: prefix entry %d has no \"Prefix\" value", ErrUnexpectedResponse, i)
+		}
+	}
+	return nil
+}
+
+// Источник префиксов, анонсируемых AS
+type PrefixProvider interface {
+	Prefixes(ctx context.Context, asNumber int) ([]Prefix, error)
+}
+
+// Источники префиксов: bgp.he.net и RIPEstat
+const (
+	PrefixSourceHE       = "he"
+	PrefixSourceRIPEstat = "ripestat"
+)
+
+// HTTP-клиент по умолчанию для запросов к API префиксов; прокси берётся
+// из переменных окружения HTTP_PROXY, HTTPS_PROXY и NO_PROXY
+func defaultHTTPClient() *http.Client {
+	return &http.Client{
+		Timeout: 10 * time.Second,
+	}
+}
+
+// HTTP-клиент для API префиксов с таймаутом и прокси из настроек.
+// Явно заданный прокси заменяет прокси из переменных окружения.
+func newHTTPClient(timeout time.Duration, proxy string) (*http.Client, error) {
+	client := defaultHTTPClient()
+	if timeout > 0 {
+		client.Timeout = timeout
+	}
+
+	if proxy != "" {
+		proxyURL, err := parseProxyURL(proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(proxyURL)
+		client.Transport = transport
+	}
+	return client, nil
+}
+
+// Разбор адреса прокси вида scheme://host:port
+func parseProxyURL(proxy string) (*url.URL, error) {
+	proxyURL, err := url.Parse(proxy)
+	if err != nil || proxyURL.Scheme == "" || proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL: %q", proxy)
+	}
+	return proxyURL, nil
+}
+
+// Предельный размер ответа API по умолчанию для флага -max-response-bytes
+const DefaultMaxResponseBytes = 64 << 20
+
+// HTTP-клиент API с ограничением размера ответа и заголовком User-Agent
+type apiClient struct {
+	client    *http.Client
+	maxBytes  int64
+	userAgent string
+	accept    string // Заголовок Accept; пусто — не передаётся
+}
+
+// Клиент API с настройками из cfg; client == nil означает клиент по умолчанию
+func newAPIClient(client *http.Client, cfg Config) apiClient {
+	return apiClient{client: client, maxBytes: cfg.MaxResponseBytes, userAgent: userAgent(cfg)}
+}
+
+// User-Agent запросов: из cfg или название программы с версией
+func userAgent(cfg Config) string {
+	if cfg.UserAgent != "" {
+		return cfg.UserAgent
+	}
+	return "maskSites/" + Version
+}
+
+// Выбор источника префиксов по имени; api.client == nil означает клиент по умолчанию,
+// api.maxBytes <= 0 — размер ответа не ограничен
+func newPrefixProvider(source string, api apiClient) (PrefixProvider, error) {
+	if api.client == nil {
+		api.client = defaultHTTPClient()
+	}
+
+	switch source {
+	case PrefixSourceHE:
+		return heProvider{api: api}, nil
+	case PrefixSourceRIPEstat:
+		return ripestatProvider{api: api}, nil
+	default:
+		return nil, fmt.Errorf("unknown prefix source: %s", source)
+	}
+}
+
+// Префиксы из отчёта bgp.he.net
+type heProvider struct {
+	api apiClient
+}
+
+func (p heProvider) Prefixes(ctx context.Context, asNumber int) ([]Prefix, error) {
+	return getIPPrefixes(ctx, p.api, asNumber)
+}
+
+// Префиксы из data API RIPEstat (announced-prefixes)
+type ripestatProvider struct {
+	api apiClient
+}
+
+// Ответ RIPEstat announced-prefixes
+type ripestatResponse struct {
+	Status string `json:"status"`
+	Data   *struct {
+		Prefixes *[]struct {
+			Prefix string `json:"prefix"`
+		} `json:"prefixes"`
+	} `json:"data"`
+}
+
+func (p ripestatProvider) Prefixes(ctx context.Context, asNumber int) ([]Prefix, error) {
+	url := fmt.Sprintf("https://stat.ripe.net/data/announced-prefixes/data.json?resource=AS%d", asNumber)
+
+	var apiResponse ripestatResponse
+	if err := p.api.fetchJSON(ctx, url, &apiResponse); err != nil {
+		return nil, err
+	}
+	return apiResponse.prefixes(asNumber)
+}
+
+// Проверка ответа RIPEstat и список префиксов из него
+func (r ripestatResponse) prefixes(asNumber int) ([]Prefix, error) {
+	if r.Status != "ok" {
+		return nil, fmt.Errorf("RIPEstat returned status %q", r.Status)
+	}
+	if r.Data == nil || r.Data.Prefixes == nil {
+		return nil, fmt.Errorf("RIPEstat AS%d: %w: missing \"data.prefixes\" field", asNumber, ErrUnexpectedResponse)
+	}
+
+	items := *r.Data.Prefixes
+	prefixes := make([]Prefix, 0, len(items))
+	for i, item := range items {
+		if item.Prefix == "" {
+			return nil, fmt.Errorf("RIPEstat AS%d: %w: prefix entry %d is empty", asNumber, ErrUnexpectedResponse, i)
+		}
+		prefixes = append(prefixes, Prefix{Prefix: item.Prefix})
+	}
+	return prefixes, nil
+}
+
+// Функция для получения IP префиксов по AS номеру; отчёт bgp.he.net не разбит
+// на страницы и возвращает все префиксы одним ответом
+func getIPPrefixes(ctx context.Context, api apiClient, asNumber int) ([]Prefix, error) {
+	url := fmt.Sprintf("https://bgp.he.net/super-lg/report/api/v1/prefixes/originated/%d", asNumber)
+
+	var raw heResponse
+	if err := api.fetchJSON(ctx, url, &raw); err != nil {
+		return nil, err
+	}
+	return raw.prefixes(asNumber)
+}
+
+// Проверка ответа bgp.he.net и список префиксов из него
+func (r heResponse) prefixes(asNumber int) ([]Prefix, error) {
+	if err := r.validate(); err != nil {
+		return nil, fmt.Errorf("bgp.he.net AS%d: %w", asNumber, err)
+	}
+
+	if len(*r.Prefixes) == 0 {
+		slog.Debug("AS has no originated prefixes", "asn", asNumber)
+	}
+	return *r.Prefixes, nil
+}
+
+// Проверка полноты списка префиксов по полям Total: неполный список — ошибка
+// ErrTruncatedResponse с cfg.Strict и предупреждение без него
+func checkTruncated(asNumber int, prefixes []Prefix, cfg Config) error {
+	total := ApiResponse{Prefixes: prefixes}.total()
+	if total <= len(prefixes) {
+		return nil
+	}
+	if cfg.Strict {
+		return fmt.Errorf("AS%d: %w: received %d of %d prefixes", asNumber, ErrTruncatedResponse, len(prefixes), total)
+	}
+	slog.Warn("Prefix list looks truncated", "asn", asNumber, "received", len(prefixes), "total", total)
+	return nil
+}
+
+// Общее число префиксов по полям Total ответа; 0, если API их не заполнил.
+// Постраничной выдачи у отчёта нет, поэтому недостающие префиксы дозапросить нельзя.
+func (r ApiResponse) total() int {
+	total := 0
+	for _, prefix := range r.Prefixes {
+		total = max(total, prefix.Total)
+	}
+	return total
+}
+
+// GET-запрос к API и потоковый разбор JSON-ответа в v
+func (c apiClient) fetchJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request for %s: %w", url, err)
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	if c.accept != "" {
+		req.Header.Set("Accept", c.accept)
+	}
+	// Сжатие запрашивается явно, а не силами транспорта, чтобы ответ распаковывался
+	// и с клиентом из Config.HTTPClient, у которого может быть свой транспорт
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to GET %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &APIStatusError{StatusCode: resp.StatusCode, Status: resp.Status,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+	}
+
+	// Тело читается потоково, без буферизации целиком; предел размера относится
+	// к распакованному ответу, слишком большой ответ обрывается
+	decoded, err := decodeBody(resp)
+	if err != nil {
+		return fmt.Errorf("response from %s: %w", url, err)
+	}
+	defer decoded.Close()
+	var body io.Reader = decoded
+	if c.maxBytes > 0 {
+		body = http.MaxBytesReader(nil, decoded, c.maxBytes)
+	}
+
+	if err := json.NewDecoder(body).Decode(v); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return fmt.Errorf("response from %s: %w: limit is %d bytes", url, ErrResponseTooLarge, maxErr.Limit)
+		}
+		return fmt.Errorf("failed to parse JSON: %w", err)
+	}
+
+	return nil
+}
+
+// Тело ответа, распакованное по заголовку Content-Encoding (gzip или deflate).
+// Для deflate принимается как формат zlib (RFC 9110), так и «сырой» поток
+// DEFLATE, который отдают некоторые серверы.
+func decodeBody(resp *http.Response) (io.ReadCloser, error) {
+	switch encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return io.NopCloser(resp.Body), nil
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress gzip response: %w", err)
+		}
+		return gz, nil
+	case "deflate":
+		br := bufio.NewReader(resp.Body)
+		if header, err := br.Peek(2); err == nil && isZlibHeader(header) {
+			zr, err := zlib.NewReader(br)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decompress deflate response: %w", err)
+			}
+			return zr, nil
+		}
+		return flate.NewReader(br), nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported Content-Encoding %q", ErrUnexpectedResponse, encoding)
+	}
+}
+
+// Заголовок потока zlib: метод сжатия 8 (deflate) и контрольная сумма первых двух байт
+func isZlibHeader(b []byte) bool {
+	return b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0
+}
+
+// Можно ли повторить запрос после ошибки: повторяем сетевые ошибки, 5xx и 429,
+// но не остальные 4xx, ошибки разбора ответа и отмену контекста
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	// Ответ получен, но непригоден: повтор вернёт то же самое
+	if errors.Is(err, ErrUnexpectedResponse) || errors.Is(err, ErrResponseTooLarge) {
+		return false
+	}
+
+	var statusErr *APIStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+
+	var netErr net.Error
+	var urlErr *url.Error
+	return errors.As(err, &netErr) || errors.As(err, &urlErr)
+}
+
+// Пауза из заголовка Retry-After: число секунд или HTTP-дата.
+// Некорректное или уже прошедшее значение даёт 0.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0)
+	}
+	return 0
+}
+
+// Предел роста задержки перед повтором (если базовая задержка не больше)
+const maxBackoffDelay = 5 * time.Minute
+
+// Задержка перед повтором: экспоненциальный рост от базовой задержки со случайным
+// разбросом; на больших attempt рост останавливается на maxBackoffDelay
+func backoffDelay(base time.Duration, attempt int) time.Duration {
+	if base <= 0 || attempt < 0 {
+		return 0
+	}
+	limit := max(base, maxBackoffDelay)
+	delay := limit
+	if base <= limit>>attempt {
+		delay = base << attempt
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int64N(int64(half)+1))
+}
+
+// FetchPrefixes возвращает префиксы AS из выбранного в cfg источника,
+// повторяя запрос при временных ошибках; с cfg.Strict неполный ответ — ошибка
+func FetchPrefixes(ctx context.Context, asNumber int, cfg Config) ([]Prefix, error) {
+	provider, err := prefixProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+	prefixes, err := getIPPrefixesWithRetry(ctx, provider, asNumber, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTruncated(asNumber, prefixes, cfg); err != nil {
+		return nil, err
+	}
+	return validPrefixes(asNumber, prefixes), nil
+}
+
+// Источник префиксов из cfg: заготовленные ответы с cfg.Fixtures или API через cfg.HTTPClient
+func prefixProvider(cfg Config) (PrefixProvider, error) {
+	if cfg.Fixtures != "" {
+		if _, err := newPrefixProvider(cfg.PrefixSource, apiClient{}); err != nil {
+			return nil, err
+		}
+		return fixtureProvider{dir: fixtureDir(cfg.Fixtures), source: cfg.PrefixSource}, nil
+	}
+
+	var err error
+	client := cfg.HTTPClient
+	if client == nil {
+		if client, err = newHTTPClient(cfg.HTTPTimeout, cfg.Proxy); err != nil {
+			return nil, err
+		}
+	}
+	return newPrefixProvider(cfg.PrefixSource, newAPIClient(client, cfg))
+}
+
+// Получение префиксов с повторами при временных ошибках API
+func getIPPrefixesWithRetry(ctx context.Context, provider PrefixProvider, asNumber int, cfg Config) ([]Prefix, error) {
+	for attempt := 0; ; attempt++ {
+		// При разомкнутом автомате запрос и оставшиеся повторы не выполняются
+		if err := cfg.breaker.allow(); err != nil {
+			return nil, err
+		}
+		if err := cfg.limiter.wait(ctx); err != nil {
+			cfg.breaker.record(ctx, err)
+			return nil, err
+		}
+
+		start := time.Now()
+		prefixes, err := provider.Prefixes(ctx, asNumber)
+		cfg.latency.observe(sourcePrefixes, start)
+		cfg.breaker.record(ctx, err)
+		if err == nil {
+			return prefixes, nil
+		}
+		if attempt >= cfg.MaxRetries || !isRetryable(err) {
+			return nil, err
+		}
+
+		// Пауза, указанная сервером в Retry-After, заменяет собственную задержку;
+		// если она не укладывается в срок контекста, повтор делается раньше —
+		// через собственную задержку, но не позже срока
+		delay := backoffDelay(cfg.RetryBaseDelay, attempt)
+		var statusErr *APIStatusError
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < statusErr.RetryAfter {
+				remaining := time.Until(deadline)
+				slog.Warn("Retry-After is beyond the deadline, retrying sooner", "asn", asNumber, "retry_after", statusErr.RetryAfter, "remaining", remaining)
+				delay = max(min(delay, remaining), 0)
+			} else {
+				delay = statusErr.RetryAfter
+			}
+		}
+		slog.Warn("Retrying prefix request", "asn", asNumber, "delay", delay, "attempt", attempt+1, "max_retries", cfg.MaxRetries, "error", err)
+
+		// Ожидание прерывается при отмене контекста
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("retry aborted: %w", ctx.Err())
+		}
+	}
+}
@@ -0,0 +1,487 @@
+package asnprefix
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Ответ bgp.he.net с двумя префиксами из трёх по полю Total
+const truncatedHEResponse = `{"prefixes":[
+	{"Prefix":"93.184.215.0/24","Count":1,"Total":3},
+	{"Prefix":"2606:2800::/32","Count":2,"Total":3}
+]}`
+
+func TestFetchPrefixesTruncated(t *testing.T) {
+	_, client := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, truncatedHEResponse)
+	}))
+	cfg := Config{PrefixSource: PrefixSourceHE, HTTPClient: client}
+
+	logs := captureLog(t)
+	prefixes, err := FetchPrefixes(context.Background(), 15133, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prefixes) != 2 {
+		t.Errorf("got %d prefixes, want 2", len(prefixes))
+	}
+	if out := logs.String(); !strings.Contains(out, "Prefix list looks truncated") || !strings.Contains(out, "total=3") {
+		t.Errorf("no truncation warning in log:\n%s", out)
+	}
+
+	cfg.Strict = true
+	if _, err := FetchPrefixes(context.Background(), 15133, cfg); !errors.Is(err, ErrTruncatedResponse) {
+		t.Errorf("FetchPrefixes with Strict: err = %v, want ErrTruncatedResponse", err)
+	}
+}
+
+func TestGetIPPrefixesCachedStrictTruncated(t *testing.T) {
+	cache := &lookupCache{Prefixes: make(map[string]cachedPrefixes)}
+	cache.storePrefixes(PrefixSourceHE, 15133, []Prefix{{Prefix: "93.184.215.0/24", Count: 1, Total: 2}})
+
+	cfg := Config{PrefixSource: PrefixSourceHE, Strict: true, prefixFlight: newPrefixFlight()}
+	if _, err := getIPPrefixesCached(context.Background(), 15133, cfg, cache); !errors.Is(err, ErrTruncatedResponse) {
+		t.Errorf("cached truncated prefixes with Strict: err = %v, want ErrTruncatedResponse", err)
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	base := 100 * time.Millisecond
+	for attempt := 0; attempt < 8; attempt++ {
+		want := base << attempt
+		if got := backoffDelay(base, attempt); got < want/2 || got > want {
+			t.Errorf("backoffDelay(%s, %d) = %s, want within [%s, %s]", base, attempt, got, want/2, want)
+		}
+	}
+	// Сдвиг на большие attempt не должен переполняться и обнулять задержку
+	for _, attempt := range []int{12, 40, 63, 64, 1000} {
+		if got := backoffDelay(base, attempt); got < maxBackoffDelay/2 || got > maxBackoffDelay {
+			t.Errorf("backoffDelay(%s, %d) = %s, want saturated at %s", base, attempt, got, maxBackoffDelay)
+		}
+	}
+	if got := backoffDelay(0, 3); got != 0 {
+		t.Errorf("backoffDelay(0, 3) = %s, want 0", got)
+	}
+}
+
+func TestRetryAfterBeyondDeadline(t *testing.T) {
+	var requests atomic.Int32
+	_, client := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"prefixes":[{"Prefix":"93.184.215.0/24"}]}`)
+	}))
+	cfg := Config{PrefixSource: PrefixSourceHE, HTTPClient: client, MaxRetries: 1, RetryBaseDelay: 10 * time.Millisecond}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	prefixes, err := FetchPrefixes(ctx, 15133, cfg)
+	if err != nil {
+		t.Fatalf("FetchPrefixes: %v", err)
+	}
+	if len(prefixes) != 1 || requests.Load() != 2 {
+		t.Errorf("got %d prefixes after %d requests, want 1 after 2", len(prefixes), requests.Load())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("retry took %s, want the own backoff instead of Retry-After", elapsed)
+	}
+}
+
+func TestValidPrefixes(t *testing.T) {
+	in := []Prefix{
+		{Prefix: "10.0.0.1/8"},
+		{Prefix: "93.184.215.0/24"},
+		{Prefix: "93.184.215.0/33"},
+		{Prefix: "not-a-prefix"},
+		{Prefix: "2606:2800:21f::1/32"},
+		{Prefix: "2606:2800::/129"},
+		{Prefix: "198.51.100.0"},
+		{Prefix: " 198.51.100.0/24 "},
+	}
+	want := []Prefix{
+		{Prefix: "10.0.0.0/8"},
+		{Prefix: "93.184.215.0/24"},
+		{Prefix: "2606:2800::/32"},
+		{Prefix: "198.51.100.0/24"},
+	}
+	if got := validPrefixes(15133, in); !reflect.DeepEqual(got, want) {
+		t.Errorf("validPrefixes = %v, want %v", got, want)
+	}
+}
+
+func TestFetchPrefixesNormalizes(t *testing.T) {
+	_, client := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"prefixes":[{"Prefix":"93.184.215.14/24"},{"Prefix":"300.0.0.0/8"},{"Prefix":"2606:2800::/32"}]}`)
+	}))
+	logs := captureLog(t)
+
+	prefixes, err := FetchPrefixes(context.Background(), 15133, Config{PrefixSource: PrefixSourceHE, HTTPClient: client})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Prefix{{Prefix: "93.184.215.0/24"}, {Prefix: "2606:2800::/32"}}
+	if !reflect.DeepEqual(prefixes, want) {
+		t.Errorf("FetchPrefixes = %v, want %v", prefixes, want)
+	}
+	out := logs.String()
+	for _, msg := range []string{"Normalized non-canonical prefix from API", "Skipping invalid prefix from API"} {
+		if !strings.Contains(out, msg) {
+			t.Errorf("no %q in log:\n%s", msg, out)
+		}
+	}
+}
+
+// Сжатие тела ответа в формате Content-Encoding; rawDeflate — deflate без обёртки zlib
+func compressBody(t *testing.T, encoding string, rawDeflate bool, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch {
+	case encoding == "gzip" || encoding == "x-gzip":
+		w = gzip.NewWriter(&buf)
+	case encoding == "deflate" && rawDeflate:
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	case encoding == "deflate":
+		w = zlib.NewWriter(&buf)
+	default:
+		return []byte(data)
+	}
+	io.WriteString(w, data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// Сервер API, отвечающий телом body с заголовком Content-Encoding encoding
+func newEncodedAPI(t *testing.T, encoding string, body []byte) apiClient {
+	_, client := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Accept-Encoding"); got != "gzip, deflate" {
+			t.Errorf("Accept-Encoding = %q, want gzip, deflate", got)
+		}
+		if encoding != "" {
+			w.Header().Set("Content-Encoding", encoding)
+		}
+		w.Write(body)
+	}))
+	return apiClient{client: client}
+}
+
+const encodedHEResponse = `{"prefixes":[{"Prefix":"93.184.215.0/24","Count":1},{"Prefix":"2606:2800::/32","Count":1}]}`
+
+func TestFetchJSONContentEncoding(t *testing.T) {
+	tests := []struct {
+		name       string
+		encoding   string
+		rawDeflate bool
+	}{
+		{"identity", "", false},
+		{"explicit identity", "identity", false},
+		{"gzip", "gzip", false},
+		{"x-gzip", "x-gzip", false},
+		{"gzip in upper case", "GZIP", false},
+		{"zlib deflate", "deflate", false},
+		{"raw deflate", "deflate", true},
+	}
+	for _, tt := range tests {
+		body := compressBody(t, strings.ToLower(tt.encoding), tt.rawDeflate, encodedHEResponse)
+		api := newEncodedAPI(t, tt.encoding, body)
+
+		var r heResponse
+		if err := api.fetchJSON(context.Background(), "https://bgp.he.net/super-lg/report/api/v1/prefixes/originated/15133", &r); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if r.Prefixes == nil || len(*r.Prefixes) != 2 || (*r.Prefixes)[1].Prefix != "2606:2800::/32" {
+			t.Errorf("%s: decoded %+v", tt.name, r)
+		}
+	}
+}
+
+func TestFetchJSONBadContentEncoding(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		want     error
+	}{
+		{"unsupported", "br", []byte(encodedHEResponse), ErrUnexpectedResponse},
+		{"several encodings", "gzip, br", []byte(encodedHEResponse), ErrUnexpectedResponse},
+		{"gzip header without gzip body", "gzip", []byte(encodedHEResponse), nil},
+		{"deflate header without deflate body", "deflate", []byte(encodedHEResponse), nil},
+	}
+	for _, tt := range tests {
+		api := newEncodedAPI(t, tt.encoding, tt.body)
+		var r heResponse
+		err := api.fetchJSON(context.Background(), "https://stat.ripe.net/data/announced-prefixes/data.json", &r)
+		if err == nil {
+			t.Errorf("%s: fetchJSON succeeded", tt.name)
+			continue
+		}
+		if tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+		// Испорченный ответ не лечится повтором
+		if tt.want != nil && isRetryable(err) {
+			t.Errorf("%s: %v is retried", tt.name, err)
+		}
+	}
+}
+
+func TestFetchJSONMaxResponseBytesDecompressed(t *testing.T) {
+	// Повторяющиеся префиксы сжимаются во много раз: ограничение должно срабатывать
+	// по распакованному размеру, а не по сжатому телу
+	var sb strings.Builder
+	sb.WriteString(`{"prefixes":[`)
+	for i := 0; i < 500; i++ {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		fmt.Fprintf(&sb, `{"Prefix":"93.184.%d.0/24","Count":1}`, i%256)
+	}
+	sb.WriteString(`]}`)
+	data := sb.String()
+
+	for _, encoding := range []string{"gzip", "deflate"} {
+		body := compressBody(t, encoding, false, data)
+		limit := int64(len(data) / 2)
+		if int64(len(body)) >= limit {
+			t.Fatalf("%s body of %d bytes does not fit under the %d byte limit", encoding, len(body), limit)
+		}
+
+		api := newEncodedAPI(t, encoding, body)
+		api.maxBytes = limit
+		var r heResponse
+		err := api.fetchJSON(context.Background(), "https://bgp.he.net/", &r)
+		if !errors.Is(err, ErrResponseTooLarge) {
+			t.Errorf("%s: err = %v, want ErrResponseTooLarge", encoding, err)
+		}
+
+		api.maxBytes = int64(len(data)) + 1
+		if err := api.fetchJSON(context.Background(), "https://bgp.he.net/", &r); err != nil {
+			t.Errorf("%s under the limit: %v", encoding, err)
+		} else if len(*r.Prefixes) != 500 {
+			t.Errorf("%s: decoded %d prefixes, want 500", encoding, len(*r.Prefixes))
+		}
+	}
+}
+
+func TestFetchPrefixesHE(t *testing.T) {
+	_, client := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/super-lg/report/api/v1/prefixes/originated/15133" {
+			t.Errorf("request path = %s", r.URL.Path)
+		}
+		if got := r.Header.Get("User-Agent"); got != "stend-check/1.0" {
+			t.Errorf("User-Agent = %q, want the configured one", got)
+		}
+		fmt.Fprint(w, encodedHEResponse)
+	}))
+	cfg := Config{PrefixSource: PrefixSourceHE, HTTPClient: client, UserAgent: "stend-check/1.0"}
+
+	prefixes, err := FetchPrefixes(context.Background(), 15133, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Prefix{{Prefix: "93.184.215.0/24", Count: 1}, {Prefix: "2606:2800::/32", Count: 1}}
+	if !reflect.DeepEqual(prefixes, want) {
+		t.Errorf("FetchPrefixes = %+v, want %+v", prefixes, want)
+	}
+}
+
+func TestFetchPrefixesRIPEstat(t *testing.T) {
+	_, client := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/data/announced-prefixes/data.json" || r.URL.Query().Get("resource") != "AS15133" {
+			t.Errorf("request URL = %s", r.URL)
+		}
+		fmt.Fprint(w, `{"status":"ok","data":{"prefixes":[{"prefix":"93.184.215.0/24"},{"prefix":"2606:2800::/32"}]}}`)
+	}))
+	cfg := Config{PrefixSource: PrefixSourceRIPEstat, HTTPClient: client}
+
+	prefixes, err := FetchPrefixes(context.Background(), 15133, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Prefix{{Prefix: "93.184.215.0/24"}, {Prefix: "2606:2800::/32"}}; !reflect.DeepEqual(prefixes, want) {
+		t.Errorf("FetchPrefixes = %+v, want %+v", prefixes, want)
+	}
+}
+
+// Ответ тестового сервера API
+type apiReply struct {
+	status     int
+	retryAfter string
+	body       string
+}
+
+// Сервер API, отвечающий по очереди заданными ответами; последний ответ
+// повторяется. Возвращает клиент и счётчик запросов.
+func newScriptedAPI(t *testing.T, replies ...apiReply) (*http.Client, *atomic.Int32) {
+	var requests atomic.Int32
+	_, client := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reply := replies[min(int(requests.Add(1))-1, len(replies)-1)]
+		if reply.retryAfter != "" {
+			w.Header().Set("Retry-After", reply.retryAfter)
+		}
+		w.WriteHeader(reply.status)
+		fmt.Fprint(w, reply.body)
+	}))
+	return client, &requests
+}
+
+func TestFetchPrefixesStatusErrors(t *testing.T) {
+	tests := []struct {
+		status   int
+		requests int32
+	}{
+		// Остальные 4xx не повторяются
+		{http.StatusNotFound, 1},
+		{http.StatusForbidden, 1},
+		// 5xx и 429 повторяются MaxRetries раз
+		{http.StatusInternalServerError, 3},
+		{http.StatusBadGateway, 3},
+		{http.StatusTooManyRequests, 3},
+	}
+	for _, tt := range tests {
+		client, requests := newScriptedAPI(t, apiReply{status: tt.status, body: "error"})
+		cfg := Config{PrefixSource: PrefixSourceHE, HTTPClient: client, MaxRetries: 2, RetryBaseDelay: time.Millisecond}
+
+		_, err := FetchPrefixes(context.Background(), 15133, cfg)
+		var statusErr *APIStatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != tt.status || !errors.Is(err, ErrAPIStatus) {
+			t.Errorf("status %d: err = %v, want an APIStatusError", tt.status, err)
+		}
+		if got := requests.Load(); got != tt.requests {
+			t.Errorf("status %d: %d requests, want %d", tt.status, got, tt.requests)
+		}
+	}
+}
+
+func TestFetchPrefixesMalformedResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		body   string
+		want   error
+	}{
+		{"not JSON", PrefixSourceHE, "<html>maintenance</html>", nil},
+		{"truncated JSON", PrefixSourceHE, `{"prefixes":[{"Prefix":"93.184.215.0/24"`, nil},
+		{"wrong type", PrefixSourceHE, `{"prefixes":{"Prefix":"93.184.215.0/24"}}`, nil},
+		{"missing field", PrefixSourceHE, `{"data":[]}`, ErrUnexpectedResponse},
+		{"empty prefix", PrefixSourceHE, `{"prefixes":[{"Count":1}]}`, ErrUnexpectedResponse},
+		{"RIPEstat missing data", PrefixSourceRIPEstat, `{"status":"ok"}`, ErrUnexpectedResponse},
+		{"RIPEstat error status", PrefixSourceRIPEstat, `{"status":"error","data":{"prefixes":[]}}`, nil},
+	}
+	for _, tt := range tests {
+		client, requests := newScriptedAPI(t, apiReply{status: http.StatusOK, body: tt.body})
+		cfg := Config{PrefixSource: tt.source, HTTPClient: client, MaxRetries: 2, RetryBaseDelay: time.Millisecond}
+
+		_, err := FetchPrefixes(context.Background(), 15133, cfg)
+		if err == nil {
+			t.Errorf("%s: FetchPrefixes succeeded", tt.name)
+			continue
+		}
+		if tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+		// Непригодный ответ не повторяется
+		if got := requests.Load(); got != 1 {
+			t.Errorf("%s: %d requests, want 1", tt.name, got)
+		}
+	}
+}
+
+func TestFetchPrefixesRetriesThenSucceeds(t *testing.T) {
+	client, requests := newScriptedAPI(t,
+		apiReply{status: http.StatusInternalServerError},
+		apiReply{status: http.StatusBadGateway},
+		apiReply{status: http.StatusOK, body: encodedHEResponse},
+	)
+	cfg := Config{PrefixSource: PrefixSourceHE, HTTPClient: client, MaxRetries: 3, RetryBaseDelay: time.Millisecond}
+
+	logs := captureLog(t)
+	prefixes, err := FetchPrefixes(context.Background(), 15133, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prefixes) != 2 || requests.Load() != 3 {
+		t.Errorf("got %d prefixes after %d requests, want 2 after 3", len(prefixes), requests.Load())
+	}
+	if got := strings.Count(logs.String(), "Retrying prefix request"); got != 2 {
+		t.Errorf("%d retry messages in log, want 2", got)
+	}
+
+	// Без повторов первая же ошибка возвращается
+	client, requests = newScriptedAPI(t, apiReply{status: http.StatusServiceUnavailable}, apiReply{status: http.StatusOK, body: encodedHEResponse})
+	cfg = Config{PrefixSource: PrefixSourceHE, HTTPClient: client, RetryBaseDelay: time.Millisecond}
+	if _, err := FetchPrefixes(context.Background(), 15133, cfg); !errors.Is(err, ErrAPIStatus) || requests.Load() != 1 {
+		t.Errorf("MaxRetries 0: err = %v after %d requests, want a status error after 1", err, requests.Load())
+	}
+}
+
+func TestFetchPrefixesRetryAfter(t *testing.T) {
+	client, requests := newScriptedAPI(t,
+		apiReply{status: http.StatusTooManyRequests, retryAfter: "1"},
+		apiReply{status: http.StatusOK, body: encodedHEResponse},
+	)
+	cfg := Config{PrefixSource: PrefixSourceHE, HTTPClient: client, MaxRetries: 1, RetryBaseDelay: time.Millisecond}
+
+	start := time.Now()
+	if _, err := FetchPrefixes(context.Background(), 15133, cfg); err != nil {
+		t.Fatal(err)
+	}
+	// Пауза из Retry-After заменяет собственную задержку в 1 мс
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Errorf("retried after %s, want the 1s from Retry-After", elapsed)
+	}
+	if requests.Load() != 2 {
+		t.Errorf("%d requests, want 2", requests.Load())
+	}
+
+	// Отмена контекста прерывает ожидание повтора
+	client, _ = newScriptedAPI(t, apiReply{status: http.StatusTooManyRequests, retryAfter: "3600"})
+	cfg.HTTPClient = client
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := FetchPrefixes(ctx, 15133, cfg); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled retry: err = %v, want context.Canceled", err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{" 5 ", 5 * time.Second},
+		{"0", 0},
+		{"-3", 0},
+		{"soon", 0},
+		{"Wed, 01 May 2024 12:00:30 GMT", 30 * time.Second},
+		// Дата в прошлом — повторять можно сразу
+		{"Wed, 01 May 2024 11:59:00 GMT", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
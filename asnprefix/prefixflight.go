@@ -0,0 +1,68 @@
+package asnprefix
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Запросы префиксов в пределах Run: каждая AS запрашивается один раз, даже если
+// она встречается у нескольких доменов, обрабатываемых параллельно; остальные
+// воркеры ждут результата первого запроса. nil выполняет каждый запрос сам.
+type prefixFlight struct {
+	mu    sync.Mutex
+	calls map[int]*prefixCall
+}
+
+// Запрос префиксов одной AS: выполняющийся или завершившийся
+type prefixCall struct {
+	done     chan struct{}
+	prefixes []Prefix
+	err      error
+}
+
+func newPrefixFlight() *prefixFlight {
+	return &prefixFlight{calls: make(map[int]*prefixCall)}
+}
+
+// Префиксы AS через fn, выполняемую один раз на AS. Неудачный запрос не
+// запоминается, а ожидавший его воркер, чей контекст ещё активен, повторяет
+// запрос сам, если тот прервался по чужому таймауту.
+func (f *prefixFlight) fetch(ctx context.Context, asNumber int, fn func(ctx context.Context) ([]Prefix, error)) ([]Prefix, error) {
+	if f == nil {
+		return fn(ctx)
+	}
+
+	for {
+		f.mu.Lock()
+		call, ok := f.calls[asNumber]
+		if !ok {
+			call = &prefixCall{done: make(chan struct{})}
+			f.calls[asNumber] = call
+			f.mu.Unlock()
+
+			call.prefixes, call.err = fn(ctx)
+			if call.err != nil {
+				f.mu.Lock()
+				delete(f.calls, asNumber)
+				f.mu.Unlock()
+			}
+			close(call.done)
+			return call.prefixes, call.err
+		}
+		f.mu.Unlock()
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if call.err == nil {
+			return call.prefixes, nil
+		}
+		interrupted := errors.Is(call.err, context.Canceled) || errors.Is(call.err, context.DeadlineExceeded)
+		if !interrupted || ctx.Err() != nil {
+			return nil, call.err
+		}
+	}
+}
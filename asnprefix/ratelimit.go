@@ -0,0 +1,53 @@
+package asnprefix
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Ограничитель частоты запросов к whois и API префиксов, общий для всех воркеров.
+// Запросы выстраиваются в очередь с равным интервалом; nil-ограничитель не ограничивает.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// Ограничитель на rate запросов в секунду; при rate <= 0 возвращается nil
+func newRateLimiter(rate float64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / rate)}
+}
+
+// Ожидание очереди на запрос; прерывается при отмене контекста
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return ctx.Err()
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	slot := l.next
+	l.next = slot.Add(l.interval)
+	l.mu.Unlock()
+
+	delay := time.Until(slot)
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
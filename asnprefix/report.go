@@ -0,0 +1,67 @@
+package asnprefix
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// DomainReport — итог обработки одного домена по этапам
+type DomainReport struct {
+	Domain     string   `json:"domain"`
+	Resolved   bool     `json:"resolved"`
+	IPs        []string `json:"ips,omitempty"`
+	WhoisOK    bool     `json:"whois_ok"`
+	ASNs       []int    `json:"asns,omitempty"`
+	PrefixesOK bool     `json:"prefixes_ok"`
+	Prefixes   int      `json:"prefixes"`
+	Unverified []string `json:"unverified,omitempty"` // Префиксы, не подтверждённые проверкой -verify, с причиной
+	Error      string   `json:"error,omitempty"`
+}
+
+// WriteReportTable выводит отчёты по доменам таблицей
+func WriteReportTable(w io.Writer, reports []DomainReport) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DOMAIN\tRESOLVE\tWHOIS\tPREFIXES\tASNS\tCOUNT\tERROR")
+	for _, r := range reports {
+		asns := make([]string, 0, len(r.ASNs))
+		for _, asNumber := range r.ASNs {
+			asns = append(asns, "AS"+strconv.Itoa(asNumber))
+		}
+		// Расхождения проверки показываются в колонке ошибки, если её нет
+		errText := r.Error
+		if errText == "" && len(r.Unverified) > 0 {
+			errText = fmt.Sprintf("%d prefixes failed verification", len(r.Unverified))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+			r.Domain, status(r.Resolved), status(r.WhoisOK), status(r.PrefixesOK),
+			strings.Join(asns, ","), r.Prefixes, errText)
+	}
+	return tw.Flush()
+}
+
+// Отметка об успехе этапа для таблицы
+func status(ok bool) string {
+	if ok {
+		return "ok"
+	}
+	return "-"
+}
+
+// SaveReport атомарно сохраняет отчёты по доменам в JSON-файл с правами mode,
+// как у выходного файла; 0 — права по умолчанию
+func SaveReport(filename string, reports []DomainReport, mode os.FileMode) error {
+	jsonData, err := json.MarshalIndent(reports, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal report: %w", err)
+	}
+
+	if err := writeFileAtomic(filename, jsonData, outputMode(Config{OutputMode: mode})); err != nil {
+		return fmt.Errorf("failed to write report file: %w", err)
+	}
+	return nil
+}
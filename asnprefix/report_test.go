@@ -0,0 +1,40 @@
+package asnprefix
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSaveReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	reports := []DomainReport{
+		{Domain: "example.com", Resolved: true, IPs: []string{"93.184.215.14"}, WhoisOK: true, ASNs: []int{15133}, PrefixesOK: true, Prefixes: 2},
+		{Domain: "gone.example", Error: "no such host"},
+	}
+
+	if err := SaveReport(path, reports, 0640); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0640 {
+		t.Errorf("report file mode = %o, want 640", perm)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []DomainReport
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, reports) {
+		t.Errorf("saved report = %+v, want %+v", got, reports)
+	}
+}
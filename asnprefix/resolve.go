@@ -0,0 +1,378 @@
+package asnprefix
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Максимальная длина цепочки CNAME, которую проходит getIPsByDig
+const maxCNAMEDepth = 8
+
+// Выполнение команды dig для получения IP-адресов домена (записи A и AAAA).
+// Если dig вернул только CNAME без адресов, запрос повторяется для цели CNAME.
+// Непустой server ("host:port") задаёт DNS-сервер вместо системного.
+// Код ответа сервера берётся из заголовка вывода: NXDOMAIN возвращается как
+// *net.DNSError с IsNotFound, SERVFAIL и REFUSED — как ошибка с кодом, а NOERROR
+// без адресов — как пустой список.
+func getIPsByDig(ctx context.Context, domain, server string) ([]string, error) {
+	var serverArgs []string
+	if server != "" {
+		host, port, _ := net.SplitHostPort(server)
+		serverArgs = []string{"@" + host, "-p", port}
+	}
+
+	name := domain
+	for depth := 0; depth <= maxCNAMEDepth; depth++ {
+		// +short скрывает код ответа, поэтому выводятся заголовок и раздел ответа
+		args := append(append([]string{}, serverArgs...), "+noall", "+comments", "+answer", name, "A", name, "AAAA")
+		cmd := execCommand(ctx, "dig", args...)
+		var out, stderr bytes.Buffer
+		cmd.Stdout = &out
+		cmd.Stderr = &stderr
+
+		if err := cmd.Run(); err != nil {
+			// Таймаут dig сообщает в stdout строкой ";; connection timed out"
+			return nil, commandError("dig", err, stderr.String()+"\n"+out.String())
+		}
+
+		answer := parseDigOutput(out.String())
+		if len(answer.ips) > 0 {
+			return answer.ips, nil
+		}
+		if err := answer.err(name, server); err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %w", domain, err)
+		}
+		if len(answer.cnames) == 0 {
+			slog.Debug("No A or AAAA records", "domain", domain, "name", name)
+			return nil, nil
+		}
+
+		// Последнее имя в выводе — конец известной части цепочки
+		name = answer.cnames[len(answer.cnames)-1]
+		slog.Debug("Following CNAME", "domain", domain, "target", name)
+	}
+	return nil, fmt.Errorf("CNAME chain for %s is longer than %d", domain, maxCNAMEDepth)
+}
+
+// Код ответа в заголовке dig: ";; ->>HEADER<<- opcode: QUERY, status: NXDOMAIN, id: 1"
+var digStatusRe = regexp.MustCompile(`status:\s*([A-Z]+)`)
+
+// Разобранный вывод dig +noall +comments +answer
+type digAnswer struct {
+	ips      []string // Адреса из записей A и AAAA
+	cnames   []string // Цели записей CNAME без завершающей точки
+	statuses []string // Коды ответа каждого запроса (A и AAAA)
+}
+
+// Разбор вывода dig: коды ответа из заголовков, адреса и цели CNAME из записей
+// раздела ответа вида "example.com. 300 IN A 93.184.215.14". Принимается и вывод
+// dig +short (например, в заготовках -fixtures): строка с адресом или с целью
+// CNAME, записанной с завершающей точкой.
+func parseDigOutput(output string) digAnswer {
+	var answer digAnswer
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, ";") {
+			if m := digStatusRe.FindStringSubmatch(line); m != nil {
+				answer.statuses = append(answer.statuses, m[1])
+			}
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 1 {
+			if ip := net.ParseIP(fields[0]); ip != nil {
+				answer.ips = append(answer.ips, ip.String())
+			} else if strings.HasSuffix(fields[0], ".") {
+				answer.cnames = append(answer.cnames, strings.TrimSuffix(fields[0], "."))
+			}
+			continue
+		}
+		if len(fields) < 5 {
+			continue
+		}
+		switch strings.ToUpper(fields[3]) {
+		case "A", "AAAA":
+			if ip := net.ParseIP(fields[4]); ip != nil {
+				answer.ips = append(answer.ips, ip.String())
+			}
+		case "CNAME":
+			answer.cnames = append(answer.cnames, strings.TrimSuffix(fields[4], "."))
+		}
+	}
+	return answer
+}
+
+// Ошибка по кодам ответа, если адресов нет: NXDOMAIN означает, что имени не
+// существует, остальные коды, кроме NOERROR, — отказ сервера
+func (a digAnswer) err(name, server string) error {
+	for _, status := range a.statuses {
+		if status == "NXDOMAIN" {
+			return &net.DNSError{Err: "no such host", Name: name, Server: server, IsNotFound: true}
+		}
+	}
+	for _, status := range a.statuses {
+		if status != "NOERROR" {
+			return &net.DNSError{Err: "server returned " + status, Name: name, Server: server, IsTemporary: status == "SERVFAIL"}
+		}
+	}
+	return nil
+}
+
+// Resolver — источник адресов домена (записи A и AAAA). Конвейер получает адреса
+// только через этот интерфейс, поэтому способ резолва можно подменить через
+// Config.DNSResolver: например, StaticResolver в тестах или DoH вместо dig.
+type Resolver interface {
+	Resolve(ctx context.Context, domain string) ([]netip.Addr, error)
+}
+
+// HostResolver — необязательное расширение Resolver для -include-mx и -include-ns:
+// имена серверов из записей MX или NS домена (без завершающей точки). Если
+// Config.DNSResolver его не реализует, записи MX и NS не запрашиваются.
+type HostResolver interface {
+	LookupHosts(ctx context.Context, domain, recordType string) ([]string, error)
+}
+
+// Резолвер выбранного в cfg способа (dig, native или doh), а с cfg.Fixtures —
+// по заготовленным ответам; cfg.DNSServer задаёт DNS-сервер вместо системного
+// для dig и native
+func newResolver(cfg Config) (Resolver, error) {
+	if cfg.Fixtures != "" {
+		return fixtureResolver{dir: fixtureDir(cfg.Fixtures)}, nil
+	}
+	server, err := dnsServerAddr(cfg.DNSServer)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Resolver {
+	case ResolverDig:
+		return digResolver{server: server}, nil
+	case ResolverNative:
+		return nativeResolver{resolver: netResolver(server)}, nil
+	case ResolverDoH:
+		return newDoHResolver(cfg.DoHURL, cfg)
+	default:
+		return nil, fmt.Errorf("unknown resolver: %s", cfg.Resolver)
+	}
+}
+
+// Резолв командой dig
+type digResolver struct {
+	server string
+}
+
+func (r digResolver) Resolve(ctx context.Context, domain string) ([]netip.Addr, error) {
+	ips, err := getIPsByDig(ctx, domain, r.server)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]netip.Addr, 0, len(ips))
+	for _, ip := range ips {
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs, nil
+}
+
+func (r digResolver) LookupHosts(ctx context.Context, domain, recordType string) ([]string, error) {
+	return getHostsByDig(ctx, domain, recordType, r.server)
+}
+
+// Резолв встроенным резолвером Go, без внешних утилит
+type nativeResolver struct {
+	resolver *net.Resolver
+}
+
+func (r nativeResolver) Resolve(ctx context.Context, domain string) ([]netip.Addr, error) {
+	addrs, err := r.resolver.LookupNetIP(ctx, "ip", domain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", domain, err)
+	}
+	// IPv4 может прийти в виде IPv4-mapped IPv6
+	for i, addr := range addrs {
+		addrs[i] = addr.Unmap()
+	}
+	return addrs, nil
+}
+
+func (r nativeResolver) LookupHosts(ctx context.Context, domain, recordType string) ([]string, error) {
+	return getHostsNative(ctx, r.resolver, domain, recordType)
+}
+
+// StaticResolver — резолвер с заранее заданными адресами доменов, без обращений
+// к сети; для тестов и воспроизводимых запусков. Домен, которого нет в карте,
+// считается несуществующим (NXDOMAIN).
+type StaticResolver map[string][]netip.Addr
+
+func (r StaticResolver) Resolve(ctx context.Context, domain string) ([]netip.Addr, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	addrs, ok := r[strings.TrimSuffix(strings.ToLower(domain), ".")]
+	if !ok {
+		return nil, fmt.Errorf("failed to resolve %s: %w", domain, &net.DNSError{Err: "no such host", Name: domain, IsNotFound: true})
+	}
+	return append([]netip.Addr(nil), addrs...), nil
+}
+
+// Встроенный резолвер Go; непустой server ("host:port") задаёт DNS-сервер вместо системного
+func netResolver(server string) *net.Resolver {
+	if server == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// Получение IP-адресов домена резолвером из cfg.DNSResolver, а без него —
+// выбранным способом (dig, native или doh)
+func resolveIPs(ctx context.Context, domain string, cfg Config) ([]string, error) {
+	resolver := cfg.DNSResolver
+	if resolver == nil {
+		var err error
+		if resolver, err = newResolver(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := withTimeout(ctx, cfg.DNSTimeout)
+	defer cancel()
+
+	addrs, err := resolver.Resolve(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, addr.String())
+	}
+	return ips, nil
+}
+
+// Приведение адреса DNS-сервера к виду "host:port" (порт по умолчанию 53).
+// Пустая строка означает системный резолвер.
+func dnsServerAddr(server string) (string, error) {
+	if server == "" {
+		return "", nil
+	}
+
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		// Адрес без порта, в том числе IPv6 без квадратных скобок
+		host, port = strings.Trim(server, "[]"), "53"
+	}
+	if host == "" {
+		return "", fmt.Errorf("invalid DNS server address: %q", server)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid DNS server port in %q", server)
+	}
+	if net.ParseIP(host) == nil && !isValidHostname(strings.ToLower(host)) {
+		return "", fmt.Errorf("invalid DNS server address: %q", server)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// Выбор представительных адресов: первый IPv4 и первый IPv6.
+// Адреса одного семейства обычно принадлежат одной AS, поэтому whois
+// достаточно выполнить по одному адресу каждого семейства.
+func representativeIPs(ips []string) []string {
+	var v4, v6 string
+	for _, ip := range ips {
+		parsed := net.ParseIP(strings.TrimSpace(ip))
+		if parsed == nil {
+			continue
+		}
+		if parsed.To4() != nil {
+			if v4 == "" {
+				v4 = parsed.String()
+			}
+		} else if v6 == "" {
+			v6 = parsed.String()
+		}
+	}
+
+	var reps []string
+	if v4 != "" {
+		reps = append(reps, v4)
+	}
+	if v6 != "" {
+		reps = append(reps, v6)
+	}
+	return reps
+}
+
+// Все различные корректные адреса в порядке появления
+func uniqueIPs(ips []string) []string {
+	var unique []string
+	seen := make(map[string]bool)
+	for _, ip := range ips {
+		parsed := net.ParseIP(strings.TrimSpace(ip))
+		if parsed == nil || seen[parsed.String()] {
+			continue
+		}
+		seen[parsed.String()] = true
+		unique = append(unique, parsed.String())
+	}
+	return unique
+}
+
+// ResolveDomain резолвит домен выбранным в cfg способом и возвращает
+// представительные адреса: первый IPv4 и первый IPv6, а с cfg.ResolveAllIPs —
+// все адреса домена. Непубличные адреса пропускаются, если не задан cfg.IncludePrivate.
+func ResolveDomain(ctx context.Context, domain string, cfg Config) ([]string, error) {
+	start := time.Now()
+	ips, err := resolveIPs(ctx, domain, cfg)
+	cfg.latency.observe(sourceDNS, start)
+	cfg.resolveGuard.record(err)
+	if err != nil {
+		return nil, fmt.Errorf("%w for domain %s: %w", ErrResolve, domain, err)
+	}
+
+	// Для частных и зарезервированных адресов whois и BGP ничего не найдут
+	if !cfg.IncludePrivate {
+		public := ips[:0:0]
+		for _, ip := range ips {
+			if isPublicIP(ip) {
+				public = append(public, ip)
+			} else {
+				slog.Warn("Skipping non-public IP", "domain", domain, "ip", ip)
+			}
+		}
+		if len(public) == 0 && len(ips) > 0 {
+			return nil, fmt.Errorf("domain %s %w", domain, ErrNoPublicIPs)
+		}
+		ips = public
+	}
+
+	var reps []string
+	if cfg.ResolveAllIPs {
+		reps = uniqueIPs(ips)
+	} else {
+		reps = representativeIPs(ips)
+	}
+	if len(reps) == 0 {
+		return nil, fmt.Errorf("%w for domain: %s", ErrNoIPs, domain)
+	}
+	slog.Debug("Domain resolved", "domain", domain, "ips", ips, "selected", reps)
+	return reps, nil
+}
@@ -0,0 +1,161 @@
+package asnprefix
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// Вывод dig +noall +comments +answer example.com A example.com AAAA
+const digExampleOutput = `;; Got answer:
+;; ->>HEADER<<- opcode: QUERY, status: NOERROR, id: 41812
+;; flags: qr rd ra; QUERY: 1, ANSWER: 1, AUTHORITY: 0, ADDITIONAL: 1
+
+;; OPT PSEUDOSECTION:
+; EDNS: version: 0, flags:; udp: 1232
+example.com.		3600	IN	A	93.184.215.14
+;; Got answer:
+;; ->>HEADER<<- opcode: QUERY, status: NOERROR, id: 2280
+;; flags: qr rd ra; QUERY: 1, ANSWER: 1, AUTHORITY: 0, ADDITIONAL: 1
+
+;; OPT PSEUDOSECTION:
+; EDNS: version: 0, flags:; udp: 1232
+example.com.		3600	IN	AAAA	2606:2800:021f:cb07:6820:80da:af6b:8b2c
+`
+
+func TestParseDigOutputAAAA(t *testing.T) {
+	answer := parseDigOutput(digExampleOutput)
+	// Адрес IPv6 приводится к каноническому сокращённому виду
+	want := digAnswer{
+		ips:      []string{"93.184.215.14", "2606:2800:21f:cb07:6820:80da:af6b:8b2c"},
+		statuses: []string{"NOERROR", "NOERROR"},
+	}
+	if !reflect.DeepEqual(answer, want) {
+		t.Errorf("parseDigOutput = %+v, want %+v", answer, want)
+	}
+	if err := answer.err("example.com", ""); err != nil {
+		t.Errorf("answer.err = %v, want nil", err)
+	}
+}
+
+func TestParseDigOutputOnlyAAAA(t *testing.T) {
+	output := `;; ->>HEADER<<- opcode: QUERY, status: NOERROR, id: 1
+;; ->>HEADER<<- opcode: QUERY, status: NOERROR, id: 2
+v6only.example.		300	IN	AAAA	2001:4860:4860::8888
+v6only.example.		300	IN	AAAA	2001:4860:4860::8844
+`
+	answer := parseDigOutput(output)
+	if want := []string{"2001:4860:4860::8888", "2001:4860:4860::8844"}; !reflect.DeepEqual(answer.ips, want) {
+		t.Errorf("parseDigOutput ips = %v, want %v", answer.ips, want)
+	}
+}
+
+func TestParseDigOutputCNAMEChain(t *testing.T) {
+	output := `;; ->>HEADER<<- opcode: QUERY, status: NOERROR, id: 1
+www.example.com.	300	IN	CNAME	www.example.com.cdn.example.net.
+www.example.com.cdn.example.net. 60 IN CNAME	edge-7.example.net.
+edge-7.example.net.	20	IN	A	93.184.215.14
+;; ->>HEADER<<- opcode: QUERY, status: NOERROR, id: 2
+www.example.com.	300	IN	CNAME	www.example.com.cdn.example.net.
+www.example.com.cdn.example.net. 60 IN CNAME	edge-7.example.net.
+`
+	answer := parseDigOutput(output)
+	if want := []string{"93.184.215.14"}; !reflect.DeepEqual(answer.ips, want) {
+		t.Errorf("parseDigOutput ips = %v, want %v", answer.ips, want)
+	}
+	// Имена CNAME записываются без завершающей точки
+	want := []string{"www.example.com.cdn.example.net", "edge-7.example.net", "www.example.com.cdn.example.net", "edge-7.example.net"}
+	if !reflect.DeepEqual(answer.cnames, want) {
+		t.Errorf("parseDigOutput cnames = %v, want %v", answer.cnames, want)
+	}
+}
+
+func TestParseDigOutputShort(t *testing.T) {
+	// dig +short перемежает цели CNAME с адресами; имена сохраняют завершающую точку
+	output := `www.example.com.cdn.example.net.
+edge-7.example.net.
+93.184.215.14
+93.184.215.15
+2606:2800:21f:cb07:6820:80da:af6b:8b2c
+`
+	answer := parseDigOutput(output)
+	want := digAnswer{
+		ips:    []string{"93.184.215.14", "93.184.215.15", "2606:2800:21f:cb07:6820:80da:af6b:8b2c"},
+		cnames: []string{"www.example.com.cdn.example.net", "edge-7.example.net"},
+	}
+	if !reflect.DeepEqual(answer, want) {
+		t.Errorf("parseDigOutput = %+v, want %+v", answer, want)
+	}
+
+	// Цепочка без адресов: whois не получает имя вместо адреса
+	answer = parseDigOutput("alias.example.com.\n")
+	if answer.ips != nil || !reflect.DeepEqual(answer.cnames, []string{"alias.example.com"}) {
+		t.Errorf("parseDigOutput of a bare CNAME = %+v", answer)
+	}
+}
+
+func TestParseDigHosts(t *testing.T) {
+	output := "10 mx1.example.com.\n\n20 mx2.example.com.\n;; connection timed out\nns1.example.net.\n"
+	want := []string{"mx1.example.com", "mx2.example.com", "ns1.example.net"}
+	if got := parseDigHosts(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDigHosts = %v, want %v", got, want)
+	}
+}
+
+func TestDigAnswerStatus(t *testing.T) {
+	tests := []struct {
+		name      string
+		output    string
+		notFound  bool // Ожидается NXDOMAIN
+		temporary bool // Ожидается временный отказ сервера
+		ok        bool // Ожидается ответ без ошибки и без адресов
+	}{
+		{
+			name: "nxdomain",
+			output: `;; ->>HEADER<<- opcode: QUERY, status: NXDOMAIN, id: 5
+;; ->>HEADER<<- opcode: QUERY, status: NXDOMAIN, id: 6
+`,
+			notFound: true,
+		},
+		{
+			name: "servfail",
+			output: `;; ->>HEADER<<- opcode: QUERY, status: SERVFAIL, id: 7
+;; ->>HEADER<<- opcode: QUERY, status: NOERROR, id: 8
+`,
+			temporary: true,
+		},
+		{
+			name:   "refused",
+			output: ";; ->>HEADER<<- opcode: QUERY, status: REFUSED, id: 9\n",
+		},
+		{
+			name: "noerror without records",
+			output: `;; ->>HEADER<<- opcode: QUERY, status: NOERROR, id: 10
+;; ->>HEADER<<- opcode: QUERY, status: NOERROR, id: 11
+`,
+			ok: true,
+		},
+	}
+	for _, tt := range tests {
+		answer := parseDigOutput(tt.output)
+		if len(answer.ips) != 0 {
+			t.Errorf("%s: unexpected addresses %v", tt.name, answer.ips)
+		}
+		err := answer.err("gone.example", "192.0.2.53:53")
+		if tt.ok {
+			if err != nil {
+				t.Errorf("%s: err = %v, want nil", tt.name, err)
+			}
+			continue
+		}
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) {
+			t.Errorf("%s: err = %v, want *net.DNSError", tt.name, err)
+			continue
+		}
+		if dnsErr.IsNotFound != tt.notFound || dnsErr.IsTemporary != tt.temporary {
+			t.Errorf("%s: IsNotFound = %t, IsTemporary = %t; want %t, %t", tt.name, dnsErr.IsNotFound, dnsErr.IsTemporary, tt.notFound, tt.temporary)
+		}
+	}
+}
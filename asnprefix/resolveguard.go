@@ -0,0 +1,70 @@
+package asnprefix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os/exec"
+	"sync"
+)
+
+// Отслеживание подряд идущих сбоев резолвера, общее для всех воркеров.
+// Когда сбоев набирается limit, запуск отменяется, чтобы не перебирать весь
+// список при недоступном DNS; nil-сторож ничего не отслеживает.
+type resolveGuard struct {
+	mu          sync.Mutex
+	limit       int
+	consecutive int
+	cancel      context.CancelCauseFunc
+}
+
+// Сторож с порогом limit; при limit <= 0 возвращается nil
+func newResolveGuard(limit int, cancel context.CancelCauseFunc) *resolveGuard {
+	if limit <= 0 {
+		return nil
+	}
+	return &resolveGuard{limit: limit, cancel: cancel}
+}
+
+// Учёт результата резолва: сбой резолвера увеличивает счётчик, любой ответ
+// (в том числе NXDOMAIN) его сбрасывает
+func (g *resolveGuard) record(err error) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !isResolverFailure(err) {
+		g.consecutive = 0
+		return
+	}
+	g.consecutive++
+	if g.consecutive == g.limit {
+		g.cancel(fmt.Errorf("%w: %d consecutive resolution failures, last error: %v", ErrResolverUnreachable, g.limit, err))
+	}
+}
+
+// Сбой самого резолвера, а не ответ об отсутствии домена: dig завершился
+// с ошибкой (например, "no servers could be reached") или DNS-запрос не получил ответа
+func isResolverFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return !dnsErr.IsNotFound
+	}
+	// DoH-сервер недоступен или отвечает ошибкой HTTP
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) || errors.Is(err, ErrAPIStatus) {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,747 @@
+// Package asnprefix собирает IP-префиксы сетей, в которых размещены домены:
+// домен резолвится в IP-адреса, по ним через whois находятся AS, а для AS
+// запрашиваются анонсируемые префиксы.
+package asnprefix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Данные, полученные заранее для bulk-режима whois
+type bulkLookup struct {
+	ips        []string         // Представительные адреса домена
+	resolveErr error            // Ошибка резолва домена
+	asNumbers  map[string][]int // Общий результат запроса к Team Cymru
+}
+
+// Обработка одного домена: резолв, поиск AS и получение префиксов.
+// В bulk-режиме адреса и AS берутся из bulk, при его отсутствии запрашиваются здесь.
+// Ход обработки по этапам записывается в report.
+func processDomain(ctx context.Context, domain string, cfg Config, bulk *bulkLookup, cache *lookupCache, report *DomainReport) ([]PrefixRecord, error) {
+	slog.Info("Processing domain", "domain", domain)
+
+	// Длительность этапов домена, в том числе неудачного: по ней видно, что тормозит запуск
+	var resolveTime, whoisTime, prefixesTime time.Duration
+	defer func() {
+		slog.Debug("Domain stage timings", "domain", domain, "resolve", resolveTime, "whois", whoisTime, "prefixes", prefixesTime)
+	}()
+	stageStart := time.Now()
+
+	var reps []string
+	var err error
+	if bulk != nil {
+		reps, err = bulk.ips, bulk.resolveErr
+	} else {
+		reps, err = ResolveDomain(ctx, domain, cfg)
+	}
+	resolveTime = time.Since(stageStart)
+	if err != nil {
+		return nil, err
+	}
+	report.Resolved = true
+
+	// Собственные адреса домена помечаются типом записи, только если
+	// включены MX или NS: иначе вывод не отличается от прежнего
+	var targets []hostTarget
+	extra := len(extraRecordTypes(cfg)) > 0
+	for _, ip := range reps {
+		target := hostTarget{ip: ip}
+		if extra {
+			target.recordType = RecordA
+		}
+		targets = append(targets, target)
+	}
+	if extra {
+		targets = append(targets, resolveExtraTargets(ctx, domain, cfg)...)
+	}
+	for _, target := range targets {
+		report.IPs = append(report.IPs, target.ip)
+	}
+	report.IPs = uniqueIPs(report.IPs)
+	resolveTime = time.Since(stageStart)
+	stageStart = time.Now()
+
+	// Ищем AS для выбранных адресов домена; одна и та же AS запрашивается один раз,
+	// но записи создаются для каждого типа записи, из которого она получена
+	type asTarget struct {
+		asn        int
+		recordType string
+	}
+	var asNumbers []int
+	var asTargets []asTarget
+	asIPs := make(map[asTarget][]string) // IP-адреса, по которым найдена AS
+	ipDone := make(map[string][]int)     // AS уже запрошенных адресов: сервер MX или NS часто делит адрес с доменом
+	var whoisErr error                   // Последняя ошибка whois: причина, если AS не найдено ни для одного адреса
+	filtered := false                    // Найденные AS отброшены фильтрами
+	for _, target := range targets {
+		ip := target.ip
+		ipASNumbers, ok := ipDone[ip]
+		if !ok {
+			var err error
+			ipASNumbers, err = lookupASNumbersCached(ctx, ip, cfg, bulk, cache)
+			ipDone[ip] = ipASNumbers
+			if err != nil {
+				slog.Error("Error getting AS number", "domain", domain, "ip", ip, "error", err)
+				whoisErr = err
+				continue
+			}
+		}
+
+		for _, asNumber := range ipASNumbers {
+			slog.Info("AS number found", "domain", domain, "ip", ip, "asn", asNumber)
+			// AS0 не бывает у анонсируемых адресов даже с -allow-private-asn
+			if asNumber == 0 || !cfg.AllowPrivateASN && !isPublicASN(asNumber) {
+				slog.Warn("Skipping private or reserved AS", "domain", domain, "ip", ip, "asn", asNumber)
+				filtered = true
+				continue
+			}
+			if !asnAllowed(cfg, asNumber) {
+				slog.Warn("Skipping filtered AS", "domain", domain, "asn", asNumber)
+				filtered = true
+				continue
+			}
+			if !containsInt(asNumbers, asNumber) {
+				asNumbers = append(asNumbers, asNumber)
+			}
+			key := asTarget{asn: asNumber, recordType: target.recordType}
+			if _, ok := asIPs[key]; !ok {
+				asTargets = append(asTargets, key)
+			}
+			asIPs[key] = appendUnique(asIPs[key], ip)
+		}
+	}
+
+	whoisTime = time.Since(stageStart)
+	stageStart = time.Now()
+
+	// ErrNoAllowedASNs — только если AS найдены, но отфильтрованы; иначе причина — ошибка whois
+	switch {
+	case len(asNumbers) > 0:
+	case filtered:
+		return nil, fmt.Errorf("%w for domain: %s", ErrNoAllowedASNs, domain)
+	case whoisErr != nil:
+		return nil, fmt.Errorf("failed to get AS numbers for domain %s: %w", domain, whoisErr)
+	default:
+		return nil, fmt.Errorf("%w for domain: %s", ErrASNNotFound, domain)
+	}
+	report.WhoisOK = true
+	report.ASNs = asNumbers
+
+	// Префиксы всех AS домена запрашиваются параллельно (в пределах ограничителя
+	// частоты); API возвращает все анонсируемые AS префиксы, как IPv4, так и IPv6
+	asPrefixes := make([][]Prefix, len(asNumbers))
+	asErrs := make([]error, len(asNumbers))
+	var wg sync.WaitGroup
+	for i, asNumber := range asNumbers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			asPrefixes[i], asErrs[i] = getIPPrefixesCached(ctx, asNumber, cfg, cache)
+		}()
+	}
+	wg.Wait()
+	prefixesTime = time.Since(stageStart)
+
+	fetchedPrefixes := make(map[int][]Prefix)
+	var prefixErrs []error // Ошибки AS без префиксов: причина, если не удалось ни для одной
+	for i, asNumber := range asNumbers {
+		prefixes, err := asPrefixes[i], asErrs[i]
+		if err == nil {
+			prefixes, err = capPrefixes(asNumber, prefixes, cfg)
+		}
+		if errors.Is(err, ErrTooManyPrefixes) {
+			slog.Warn("Skipping AS with too many prefixes", "domain", domain, "asn", asNumber, "error", err)
+			prefixErrs = append(prefixErrs, fmt.Errorf("AS%d: %w", asNumber, err))
+			continue
+		}
+		if err != nil {
+			slog.Error("Error getting IP prefixes", "domain", domain, "asn", asNumber, "error", err)
+			prefixErrs = append(prefixErrs, fmt.Errorf("AS%d: %w", asNumber, err))
+			continue
+		}
+		// Пустой список — признак снятой с регистрации или частной AS либо сбоя API
+		if len(prefixes) == 0 {
+			slog.Warn("AS returned 0 prefixes", "domain", domain, "asn", asNumber)
+		}
+		fetchedPrefixes[asNumber] = prefixes
+	}
+	fetched := len(fetchedPrefixes)
+
+	var results []PrefixRecord
+	for _, key := range asTargets {
+		ips := asIPs[key]
+		for _, prefix := range fetchedPrefixes[key.asn] {
+			record := PrefixRecord{
+				Domain:     domain,
+				IDN:        idnForm(domain),
+				IP:         ips[0],
+				ASN:        key.asn,
+				RecordType: key.recordType,
+				Prefix:     prefix.Prefix,
+			}
+			if len(ips) > 1 {
+				record.IPs = ips
+			}
+			results = append(results, record)
+		}
+	}
+
+	if fetched == 0 {
+		return nil, fmt.Errorf("failed to get IP prefixes for domain %s: %w", domain, errors.Join(prefixErrs...))
+	}
+	report.PrefixesOK = true
+	report.Prefixes = len(results)
+	return results, nil
+}
+
+// Обработка AS, заданной напрямую: только запрос префиксов, без резолва и whois
+func processASN(ctx context.Context, asNumber int, cfg Config, cache *lookupCache, report *DomainReport) ([]PrefixRecord, error) {
+	label := "AS" + strconv.Itoa(asNumber)
+	slog.Info("Processing AS", "asn", asNumber)
+	report.ASNs = []int{asNumber}
+
+	// Те же фильтры, что и для AS, найденных через whois
+	if asNumber == 0 || !cfg.AllowPrivateASN && !isPublicASN(asNumber) {
+		slog.Warn("Skipping private or reserved AS", "asn", asNumber)
+		return nil, fmt.Errorf("%w for %s: private or reserved AS", ErrNoAllowedASNs, label)
+	}
+	if !asnAllowed(cfg, asNumber) {
+		slog.Warn("Skipping filtered AS", "asn", asNumber)
+		return nil, fmt.Errorf("%w for %s: excluded by -allow-asn or -deny-asn", ErrNoAllowedASNs, label)
+	}
+
+	prefixes, err := getIPPrefixesCached(ctx, asNumber, cfg, cache)
+	if err == nil {
+		prefixes, err = capPrefixes(asNumber, prefixes, cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get IP prefixes for %s: %w", label, err)
+	}
+	if len(prefixes) == 0 {
+		slog.Warn("AS returned 0 prefixes", "asn", asNumber)
+	}
+
+	results := make([]PrefixRecord, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		results = append(results, PrefixRecord{
+			Domain: label,
+			ASN:    asNumber,
+			Prefix: prefix.Prefix,
+		})
+	}
+	report.PrefixesOK = true
+	report.Prefixes = len(results)
+	return results, nil
+}
+
+// Получение номеров AS для адреса: из результатов bulk-запроса, из кэша или через whois
+func lookupASNumbersCached(ctx context.Context, ip string, cfg Config, bulk *bulkLookup, cache *lookupCache) ([]int, error) {
+	if asNumbers, ok := cfg.asnMap.lookup(ip); ok {
+		slog.Debug("AS numbers taken from ASN map", "ip", ip, "asns", asNumbers)
+		return asNumbers, nil
+	}
+	key := asnCacheKey(ip, cfg)
+	if asNumbers, ok := bulk.lookup(ip); ok {
+		cache.storeASNumbers(key, asNumbers)
+		return asNumbers, nil
+	}
+	if asNumbers, ok := cache.asNumbers(key); ok {
+		slog.Debug("AS numbers taken from cache", "ip", ip, "asns", asNumbers)
+		return asNumbers, nil
+	}
+
+	asNumbers, err := LookupASN(ctx, ip, cfg)
+	if err != nil {
+		return nil, err
+	}
+	cache.storeASNumbers(key, asNumbers)
+	return asNumbers, nil
+}
+
+// Получение префиксов AS из кэша или от источника префиксов; общая для
+// доменов AS запрашивается за запуск один раз
+func getIPPrefixesCached(ctx context.Context, asNumber int, cfg Config, cache *lookupCache) ([]Prefix, error) {
+	return cfg.prefixFlight.fetch(ctx, asNumber, func(ctx context.Context) ([]Prefix, error) {
+		if prefixes, ok := cache.prefixes(cfg.PrefixSource, asNumber); ok {
+			slog.Debug("Prefixes taken from cache", "asn", asNumber, "count", len(prefixes))
+			// Неполный список мог попасть в кэш при запуске без -strict
+			if cfg.Strict {
+				if err := checkTruncated(asNumber, prefixes, cfg); err != nil {
+					return nil, err
+				}
+			}
+			return prefixes, nil
+		}
+
+		prefixes, err := FetchPrefixes(ctx, asNumber, cfg)
+		if err != nil {
+			return nil, err
+		}
+		cache.storePrefixes(cfg.PrefixSource, asNumber, prefixes)
+		return prefixes, nil
+	})
+}
+
+// Ограничение числа префиксов AS по cfg.MaxPrefixes: AS сверх предела
+// пропускается с ErrTooManyPrefixes или обрезается до первых префиксов
+func capPrefixes(asNumber int, prefixes []Prefix, cfg Config) ([]Prefix, error) {
+	if cfg.MaxPrefixes <= 0 || len(prefixes) <= cfg.MaxPrefixes {
+		return prefixes, nil
+	}
+	if cfg.MaxPrefixesAction == MaxPrefixesTruncate {
+		slog.Warn("Truncating prefixes of a large AS", "asn", asNumber, "prefixes", len(prefixes), "max_prefixes", cfg.MaxPrefixes)
+		return prefixes[:cfg.MaxPrefixes], nil
+	}
+	return nil, fmt.Errorf("%w: AS%d announces %d prefixes, more than -max-prefixes %d", ErrTooManyPrefixes, asNumber, len(prefixes), cfg.MaxPrefixes)
+}
+
+// Поиск AS в результатах bulk-запроса
+func (b *bulkLookup) lookup(ip string) ([]int, bool) {
+	if b == nil {
+		return nil, false
+	}
+	asNumbers, ok := b.asNumbers[ip]
+	return asNumbers, ok
+}
+
+// Запуск fn(i) для i от 0 до n-1 пулом из concurrency воркеров.
+// При отмене контекста новые задачи воркерам не раздаются.
+func runWorkers(ctx context.Context, n, concurrency int, fn func(i int)) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+
+feed:
+	for i := 0; i < n; i++ {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			slog.Warn("Processing cancelled", "error", ctx.Err())
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// Подготовка bulk-режима: резолв всех доменов и один общий запрос AS к Team Cymru
+// для адресов, которых нет в кэше.
+// Если общий запрос не удался, AS будут запрошены по каждому адресу отдельно.
+func prepareBulkLookups(ctx context.Context, domains []string, cfg Config, cache *lookupCache) []*bulkLookup {
+	lookups := make([]*bulkLookup, len(domains))
+	runWorkers(ctx, len(domains), cfg.Concurrency, func(i int) {
+		// Домены с результатом в кэше не резолвятся и не попадают в общий запрос
+		if _, ok := cache.domainResult(domainCacheKey(domains[i], cfg)); ok {
+			lookups[i] = &bulkLookup{}
+			return
+		}
+		// Зависший резолв прерывается по таймауту домена, как и при обработке домена
+		domainCtx, cancel := withTimeout(ctx, cfg.DomainTimeout)
+		defer cancel()
+		ips, err := ResolveDomain(domainCtx, domains[i], cfg)
+		if err != nil && ctx.Err() == nil && errors.Is(domainCtx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("domain timed out after %s: %w", cfg.DomainTimeout, err)
+		}
+		lookups[i] = &bulkLookup{ips: ips, resolveErr: err}
+	})
+
+	var allIPs []string
+	seen := make(map[string]bool)
+	for _, l := range lookups {
+		if l == nil {
+			continue
+		}
+		for _, ip := range l.ips {
+			if _, cached := cache.asNumbers(asnCacheKey(ip, cfg)); cached {
+				continue
+			}
+			if _, mapped := cfg.asnMap.lookup(ip); mapped {
+				continue
+			}
+			if !seen[ip] {
+				seen[ip] = true
+				allIPs = append(allIPs, ip)
+			}
+		}
+	}
+
+	var asNumbers map[string][]int
+	if len(allIPs) > 0 {
+		err := cfg.limiter.wait(ctx)
+		if err == nil {
+			bulkCtx, cancel := withTimeout(ctx, cfg.WhoisTimeout)
+			start := time.Now()
+			var names map[int]string
+			asNumbers, names, err = getASNumbersBulk(bulkCtx, allIPs)
+			cfg.latency.observe(sourceWhois, start)
+			cancel()
+			if cfg.ASNames {
+				for asNumber, name := range names {
+					cache.storeASName(asNumber, name)
+				}
+			}
+		}
+		if err != nil {
+			slog.Warn("Bulk whois failed, falling back to per-IP lookups", "error", err)
+		}
+	}
+	for _, l := range lookups {
+		if l != nil {
+			l.asNumbers = asNumbers
+		}
+	}
+	return lookups
+}
+
+// Ошибка для доменов, до которых не дошла очередь из-за отмены
+var errNotProcessed = errors.New("domain was not processed")
+
+// Параллельная обработка доменов и заданных напрямую AS пулом воркеров.
+// Возвращает имена записей (домены, затем метки вида "AS64500"), а также результаты,
+// отчёты и ошибки каждой записи по её индексу в этом списке.
+func processDomains(ctx context.Context, domainList []string, asns []int, cfg Config, cache *lookupCache) ([]string, [][]PrefixRecord, []DomainReport, []error) {
+	var bulk []*bulkLookup
+	if cfg.Whois == WhoisBulk && cfg.Fixtures == "" {
+		bulk = prepareBulkLookups(ctx, domainList, cfg, cache)
+	}
+
+	domains := domainList[:len(domainList):len(domainList)]
+	for _, asNumber := range asns {
+		domains = append(domains, "AS"+strconv.Itoa(asNumber))
+	}
+
+	// Результаты каждого домена хранятся по его индексу, поэтому
+	// синхронизация не нужна: каждый воркер пишет только в свою ячейку
+	perDomain := make([][]PrefixRecord, len(domains))
+	reports := make([]DomainReport, len(domains))
+	errs := make([]error, len(domains))
+	for i := range errs {
+		errs[i] = errNotProcessed
+		reports[i] = DomainReport{Domain: domains[i], Error: errNotProcessed.Error()}
+	}
+
+	var done atomic.Int64
+	runWorkers(ctx, len(domains), cfg.Concurrency, func(i int) {
+		if cfg.OnProgress != nil {
+			defer func() { cfg.OnProgress(int(done.Add(1)), len(domains)) }()
+		}
+
+		if i >= len(domainList) {
+			reports[i] = DomainReport{Domain: domains[i]}
+			res, err := processASN(ctx, asns[i-len(domainList)], cfg, cache, &reports[i])
+			errs[i] = err
+			if err != nil {
+				reports[i].Error = err.Error()
+				slog.Error("AS failed", "asn", asns[i-len(domainList)], "error", err)
+				streamRecords(cfg, failedRecords(domains[i:i+1], errs[i:i+1]))
+				return
+			}
+			perDomain[i] = res
+			streamRecords(cfg, res)
+			return
+		}
+
+		// Результат домена из контрольной точки или целиком из кэша, без обращений к сети
+		key := domainCacheKey(domains[i], cfg)
+		entry, ok := cfg.checkpoint.lookup(domains[i], key)
+		if ok {
+			slog.Debug("Domain result taken from checkpoint", "domain", domains[i], "prefixes", len(entry.Records))
+		} else if entry, ok = cache.domainResult(key); ok {
+			slog.Debug("Domain result taken from cache", "domain", domains[i], "prefixes", len(entry.Records))
+			cfg.checkpoint.record(domains[i], key, entry)
+		}
+		if ok {
+			reports[i] = DomainReport{Domain: domains[i], Resolved: true, IPs: entry.IPs, WhoisOK: true, ASNs: entry.ASNs, PrefixesOK: true, Prefixes: len(entry.Records)}
+			errs[i] = nil
+			perDomain[i] = tagRecords(entry.Records, cfg.domainTags[domains[i]])
+			streamRecords(cfg, perDomain[i])
+			return
+		}
+
+		var lookup *bulkLookup
+		if bulk != nil {
+			if lookup = bulk[i]; lookup == nil {
+				return // Домен не был обработан из-за отмены
+			}
+		}
+
+		// Зависший dig или whois прерывается по таймауту домена, остальные домены продолжаются
+		domainCtx, cancel := withTimeout(ctx, cfg.DomainTimeout)
+		defer cancel()
+
+		reports[i] = DomainReport{Domain: domains[i]}
+		res, err := processDomain(domainCtx, domains[i], cfg, lookup, cache, &reports[i])
+		if err != nil && ctx.Err() == nil && errors.Is(domainCtx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("domain timed out after %s: %w", cfg.DomainTimeout, err)
+		}
+		errs[i] = err
+		if err != nil {
+			reports[i].Error = err.Error()
+			slog.Error("Domain failed", "domain", domains[i], "error", err)
+			streamRecords(cfg, failedRecords(domains[i:i+1], errs[i:i+1]))
+			return
+		}
+		// В кэше записи хранятся без меток: метки берутся из текущего входного файла
+		done := cachedDomain{IPs: reports[i].IPs, ASNs: reports[i].ASNs, Records: res}
+		cache.storeDomainResult(key, done)
+		cfg.checkpoint.record(domains[i], key, done)
+		perDomain[i] = tagRecords(res, cfg.domainTags[domains[i]])
+		streamRecords(cfg, perDomain[i])
+	})
+
+	return domains, perDomain, reports, errs
+}
+
+// Вывод записей в поток NDJSON, если он включён; записи об ошибках — только с IncludeFailed
+func streamRecords(cfg Config, records []PrefixRecord) {
+	if cfg.stream == nil || len(records) == 0 {
+		return
+	}
+	if records[0].Error != "" && !cfg.IncludeFailed {
+		return
+	}
+	cfg.stream.write(filterOutput(records, cfg))
+}
+
+// Можно ли выводить NDJSON по мере обработки: сведение префиксов, названия AS,
+// -append и -sort требуют всех записей, поэтому с ними вывод выполняется в конце
+func streamable(cfg Config) bool {
+	return cfg.Format == FormatNDJSON && cfg.Output != "" && !cfg.Aggregate && !cfg.ASNames && !cfg.Append && !cfg.Sort
+}
+
+// Result — итог запуска конвейера
+type Result struct {
+	Domains []string       // Нормализованный список доменов и метки заданных напрямую AS
+	Errors  []error        // Ошибка каждого домена по его индексу, nil при успехе
+	Reports []DomainReport // Отчёт по этапам для каждого домена, по тому же индексу
+	Records []PrefixRecord // Собранные префиксы (после дедупликации, если она включена)
+	Diff    *PrefixDiff    // Изменения относительно cfg.DiffAgainst; nil, если сравнение не запрошено
+
+	Duration time.Duration          // Длительность обработки доменов
+	Latency  map[string]LatencyStat // Задержки запросов по источникам: dns, whois, prefixes
+}
+
+// Failed возвращает число доменов, для которых не удалось получить префиксы
+func (r *Result) Failed() int {
+	failed := 0
+	for _, err := range r.Errors {
+		if err != nil {
+			failed++
+		}
+	}
+	return failed
+}
+
+// Run выполняет весь конвейер: читает домены из cfg.Input, собирает префиксы
+// и, если задан cfg.Output, сохраняет их в выбранном формате.
+// Ошибки отдельных доменов возвращаются в Result.Errors, а не как ошибка Run.
+func Run(ctx context.Context, cfg Config) (*Result, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Общий дедлайн запуска, включая загрузку списков доменов по URL;
+	// по его истечении все запущенные dig/whois завершаются
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	// При заданных напрямую AS список доменов может отсутствовать
+	var domains []string
+	var err error
+	if len(cfg.ASNs) == 0 || cfg.Input != "" || len(cfg.Domains) > 0 {
+		if domains, cfg.domainTags, err = loadDomainList(ctx, cfg); err != nil {
+			return nil, err
+		}
+	}
+	if len(domains) == 0 && len(cfg.ASNs) == 0 {
+		return nil, ErrNoDomains
+	}
+
+	// dig и whois нужны только для доменов
+	if len(domains) > 0 {
+		if err := checkTools(cfg); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := cfg.DNSResolver.(HostResolver); cfg.DNSResolver != nil && !ok && len(extraRecordTypes(cfg)) > 0 {
+		slog.Warn("DNSResolver does not implement HostResolver, MX and NS records are not looked up")
+	}
+
+	// Контрольная точка открывается до обработки: при возобновлении из неё берутся готовые домены
+	if cfg.checkpoint, err = openCheckpoint(cfg); err != nil {
+		return nil, err
+	}
+
+	// Известные соответствия адресов и AS, для которых whois не нужен
+	if cfg.ASNMap != "" {
+		if cfg.asnMap, err = loadASNMap(cfg.ASNMap); err != nil {
+			return nil, err
+		}
+		slog.Info("ASN map loaded", "path", cfg.ASNMap, "entries", cfg.asnMap.len())
+	}
+
+	// Базовый набор читается до сохранения результата, так как это может быть тот же файл
+	var baseline map[string]bool
+	if cfg.DiffAgainst != "" {
+		if baseline, err = loadBaseline(cfg.DiffAgainst); err != nil {
+			return nil, err
+		}
+	}
+
+	// Существующий выходной файл для -append тоже читается до начала обработки
+	var existing []PrefixRecord
+	if cfg.Append {
+		if existing, err = loadExistingRecords(cfg.Output, cfg.Format); err != nil {
+			return nil, err
+		}
+	}
+
+	// Ограничитель частоты общий для всех воркеров, чтобы параллельность его не обходила
+	// Заготовленные ответы -fixtures читаются с диска без ограничения частоты
+	if cfg.Fixtures == "" {
+		cfg.limiter = newRateLimiter(cfg.Rate)
+	}
+	cfg.latency = newLatencyRecorder()
+	cfg.prefixFlight = newPrefixFlight()
+	start := time.Now()
+
+	// Один HTTP-клиент на запуск, чтобы соединения с API переиспользовались
+	if cfg.HTTPClient == nil {
+		if cfg.HTTPClient, err = newHTTPClient(cfg.HTTPTimeout, cfg.Proxy); err != nil {
+			return nil, err
+		}
+	}
+
+	// При недоступном резолвере запуск отменяется сторожем с указанием причины
+	ctx, cancelRun := context.WithCancelCause(ctx)
+	defer cancelRun(nil)
+	cfg.resolveGuard = newResolveGuard(cfg.MaxResolveFailures, cancelRun)
+	cfg.breaker = newCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown)
+
+	// Кэш whois и префиксов между запусками; заготовленные ответы -fixtures в него не попадают
+	var cache *lookupCache
+	if !cfg.NoCache && cfg.Fixtures == "" {
+		cache, err = loadLookupCache(filepath.Join(cfg.CacheDir, cacheFileName), cfg)
+		if err != nil {
+			slog.Warn("Error loading cache, starting with an empty one", "error", err)
+		}
+	}
+
+	if streamable(cfg) {
+		if cfg.stream, err = newNDJSONStream(cfg.Output, cfg.Dedup, outputMode(cfg)); err != nil {
+			return nil, err
+		}
+	}
+
+	// Обрабатываем домены параллельно, порядок результатов совпадает с порядком доменов
+	domains, perDomain, reports, errs := processDomains(ctx, domains, cfg.ASNs, cfg, cache)
+
+	// После прерывания или сбоя сохранения контрольная точка позволит продолжить с места остановки
+	if err := cfg.checkpoint.flush(); err != nil {
+		slog.Error("Error saving checkpoint", "error", err)
+	}
+
+	result := &Result{
+		Domains:  domains,
+		Errors:   errs,
+		Reports:  reports,
+		Duration: time.Since(start),
+		Latency:  cfg.latency.snapshot(),
+	}
+	if cfg.Dedup {
+		result.Records = dedupPrefixes(domains, perDomain)
+	} else {
+		result.Records = flattenResults(perDomain)
+	}
+	// Проверка до сведения, так как сведённые префиксы в BGP не анонсируются
+	if cfg.Verify {
+		verifyRecords(ctx, result.Records, domains, result.Reports, cfg)
+	}
+	if cfg.Aggregate {
+		result.Records = aggregateRecords(result.Records)
+	}
+	result.Records = filterOutput(result.Records, cfg)
+	if cfg.Sort {
+		sortRecords(result.Records)
+	}
+	if cfg.ASNames {
+		enrichASNames(ctx, result.Records, cfg, cache)
+	}
+
+	if err := cache.save(); err != nil {
+		slog.Error("Error saving cache", "error", err)
+	}
+
+	// Прерванный из-за резолвера запуск не перезаписывает предыдущий результат
+	if cause := context.Cause(ctx); errors.Is(cause, ErrResolverUnreachable) {
+		cfg.stream.discard()
+		return result, cause
+	}
+	if baseline != nil {
+		result.Diff = diffPrefixes(baseline, result.Records)
+		slog.Info("Compared with baseline", "path", cfg.DiffAgainst, "added", len(result.Diff.Added), "removed", len(result.Diff.Removed))
+	}
+
+	if cfg.stream != nil {
+		if err := cfg.stream.close(); err != nil {
+			return result, fmt.Errorf("failed to save prefixes to %s: %w", cfg.Output, err)
+		}
+	} else if cfg.Output != "" {
+		output := result.Records
+		if cfg.Append {
+			output = mergeRecords(existing, output)
+			if cfg.Aggregate {
+				output = aggregateRecords(output)
+			}
+			output = filterOutput(output, cfg)
+			if cfg.Sort {
+				sortRecords(output)
+			}
+			slog.Info("Merged with existing output", "path", cfg.Output, "existing", len(existing), "total", len(output))
+		}
+		if cfg.IncludeFailed {
+			output = append(output[:len(output):len(output)], failedRecords(domains, errs)...)
+		}
+		if err := savePrefixesToFile(output, cfg.Output, cfg); err != nil {
+			return result, fmt.Errorf("failed to save prefixes to %s: %w", cfg.Output, err)
+		}
+	}
+	if cfg.OutputDir != "" {
+		if err := saveDomainFiles(cfg.OutputDir, domains, perDomain, errs, cfg); err != nil {
+			return result, fmt.Errorf("failed to save per-domain files to %s: %w", cfg.OutputDir, err)
+		}
+	}
+
+	// Запуск завершён целиком, возобновлять нечего
+	if ctx.Err() == nil {
+		if err := cfg.checkpoint.remove(); err != nil {
+			slog.Error("Error removing checkpoint", "error", err)
+		}
+	} else if cfg.checkpoint != nil {
+		slog.Info("Run can be resumed", "checkpoint", cfg.checkpoint.path)
+	}
+	return result, nil
+}
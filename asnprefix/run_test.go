@@ -0,0 +1,346 @@
+package asnprefix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Резолвер, отвечающий только после отмены контекста, как зависший DNS-сервер
+type hangingResolver struct{}
+
+func (hangingResolver) Resolve(ctx context.Context, domain string) ([]netip.Addr, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestPrepareBulkLookupsDomainTimeout(t *testing.T) {
+	cfg := Config{
+		Whois:         WhoisBulk,
+		Concurrency:   2,
+		DomainTimeout: 50 * time.Millisecond,
+		DNSResolver:   hangingResolver{},
+	}
+
+	start := time.Now()
+	lookups := prepareBulkLookups(context.Background(), []string{"slow.example", "stuck.example"}, cfg, nil)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("prepareBulkLookups took %s despite the domain timeout", elapsed)
+	}
+	for i, l := range lookups {
+		if l == nil || !errors.Is(l.resolveErr, context.DeadlineExceeded) {
+			t.Errorf("lookup %d: %+v, want a domain timeout error", i, l)
+		}
+	}
+}
+
+// Директория -fixtures из файлов "вид/имя" → содержимое
+func writeFixtures(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// Заготовленные ответы для example.com (AS15133) и www.example.com, CNAME на него
+var exampleFixtures = map[string]string{
+	"dig/example.com":                              digExampleOutput,
+	"dig/www.example.com":                          "example.com.\n",
+	"whois/93.184.215.14":                          "origin:         AS15133\n",
+	"whois/2606:2800:21f:cb07:6820:80da:af6b:8b2c": "OriginAS:       AS15133\n",
+	"whois/AS15133":                                "15133   | EDGECAST, US\n",
+	"prefixes/AS15133.json":                        `{"prefixes":[{"Prefix":"93.184.215.0/24","Count":1},{"Prefix":"2606:2800::/32","Count":1}]}`,
+}
+
+// Настройки библиотеки для запуска на заготовленных ответах
+func fixtureConfig(dir string) Config {
+	return Config{
+		Format:            FormatJSON,
+		Family:            FamilyBoth,
+		Resolver:          ResolverDig,
+		Whois:             WhoisCommand,
+		PrefixSource:      PrefixSourceHE,
+		MaxPrefixesAction: MaxPrefixesSkip,
+		Concurrency:       2,
+		Fixtures:          dir,
+		NoCache:           true,
+	}
+}
+
+func TestExportedAPIFixtures(t *testing.T) {
+	cfg := fixtureConfig(writeFixtures(t, exampleFixtures))
+	ctx := context.Background()
+
+	ips, err := ResolveDomain(ctx, "www.example.com", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"93.184.215.14", "2606:2800:21f:cb07:6820:80da:af6b:8b2c"}; !reflect.DeepEqual(ips, want) {
+		t.Errorf("ResolveDomain = %v, want %v", ips, want)
+	}
+	if _, err := ResolveDomain(ctx, "gone.example", cfg); err == nil {
+		t.Error("ResolveDomain of a name without fixture succeeded")
+	}
+
+	asNumbers, err := LookupASN(ctx, "93.184.215.14", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{15133}; !reflect.DeepEqual(asNumbers, want) {
+		t.Errorf("LookupASN = %v, want %v", asNumbers, want)
+	}
+
+	prefixes, err := FetchPrefixes(ctx, 15133, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prefixes) != 2 || prefixes[0].Prefix != "93.184.215.0/24" || prefixes[1].Prefix != "2606:2800::/32" {
+		t.Errorf("FetchPrefixes = %+v", prefixes)
+	}
+}
+
+func TestRunFixtures(t *testing.T) {
+	cfg := fixtureConfig(writeFixtures(t, exampleFixtures))
+	cfg.Domains = []string{"www.example.com", "gone.example", "WWW.example.com"}
+	cfg.Dedup = true
+	cfg.ASNames = true
+
+	result, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"www.example.com", "gone.example"}; !reflect.DeepEqual(result.Domains, want) {
+		t.Errorf("Domains = %v, want %v", result.Domains, want)
+	}
+	if len(result.Errors) != 2 || result.Errors[0] != nil || result.Errors[1] == nil {
+		t.Errorf("Errors = %v, want a failure of gone.example only", result.Errors)
+	}
+	if result.Failed() != 1 {
+		t.Errorf("Failed() = %d, want 1", result.Failed())
+	}
+
+	// Префиксы AS относятся к обоим адресам домена, дедупликация объединяет их
+	ips := []string{"93.184.215.14", "2606:2800:21f:cb07:6820:80da:af6b:8b2c"}
+	want := []PrefixRecord{
+		{Domain: "www.example.com", Domains: []string{"www.example.com"}, IP: ips[0], IPs: ips, ASN: 15133, ASName: "EDGECAST, US", Prefix: "93.184.215.0/24"},
+		{Domain: "www.example.com", Domains: []string{"www.example.com"}, IP: ips[0], IPs: ips, ASN: 15133, ASName: "EDGECAST, US", Prefix: "2606:2800::/32"},
+	}
+	if !reflect.DeepEqual(result.Records, want) {
+		t.Errorf("Records =\n%+v\nwant\n%+v", result.Records, want)
+	}
+
+	// Без доменов и AS запускать нечего
+	cfg.Domains = []string{"# только комментарий"}
+	if _, err := Run(context.Background(), cfg); err == nil {
+		t.Error("Run without domains succeeded")
+	}
+	cfg.Format = "xml"
+	if _, err := Run(context.Background(), cfg); err == nil {
+		t.Error("Run accepted an unknown format")
+	}
+}
+
+// Конвейер целиком без сети: адреса из StaticResolver, whois из фейковой команды,
+// префиксы с тестового сервера API через Config.HTTPClient
+func pipelineConfig(t *testing.T) Config {
+	t.Helper()
+	fakeCommands(t, map[string]fakeResult{
+		"whois 93.184.215.14":   {Stdout: "NetRange:       93.184.208.0 - 93.184.223.255\nOriginAS:       AS15133\n"},
+		"whois 2606:2800::14":   {Stdout: "inet6num:       2606:2800::/32\norigin:         AS15133\n"},
+		"whois 142.250.74.46":   {Stdout: "NetRange:       142.250.0.0 - 142.251.255.255\nOriginAS:       AS15169\n"},
+		"whois 151.101.1.140":   {Stdout: "% no origin in this response\n"},
+		"whois 185.199.108.153": {Stdout: "NetRange:       185.199.108.0 - 185.199.111.255\nOriginAS:       AS54113\n"},
+		"whois 140.82.121.4":    {Stdout: "NetRange:       140.82.112.0 - 140.82.127.255\nOriginAS:       AS36459\n"},
+	})
+	_, client := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/super-lg/report/api/v1/prefixes/originated/15133":
+			fmt.Fprint(w, `{"prefixes":[{"Prefix":"93.184.215.0/24","Count":1},{"Prefix":"2606:2800::/32","Count":1}]}`)
+		case "/super-lg/report/api/v1/prefixes/originated/15169":
+			fmt.Fprint(w, `{"prefixes":[{"Prefix":"142.250.0.0/15","Count":1}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	cfg := fixtureConfig("")
+	cfg.DNSResolver = StaticResolver{
+		"example.com": {netip.MustParseAddr("93.184.215.14"), netip.MustParseAddr("2606:2800::14")},
+		"example.net": {netip.MustParseAddr("93.184.215.14")},
+		"google.com":  {netip.MustParseAddr("142.250.74.46")},
+		"reddit.com":  {netip.MustParseAddr("151.101.1.140")},
+		"github.io":   {netip.MustParseAddr("185.199.108.153")},
+		"github.com":  {netip.MustParseAddr("140.82.121.4")},
+	}
+	cfg.HTTPClient = client
+	cfg.Domains = []string{"example.com", "google.com", "example.net", "gone.example", "reddit.com", "github.io", "github.com"}
+	// Для AS54113 API отвечает 404, AS36459 отброшена -deny-asn
+	cfg.DenyASNs = []int{36459}
+	cfg.Dedup = true
+	cfg.Sort = true
+	return cfg
+}
+
+// Префиксы AS15133 относятся к обоим адресам example.com, а дедупликация
+// добавляет к ним example.net с тем же адресом
+const pipelineJSON = `[
+    {
+        "domain": "example.com",
+        "domains": [
+            "example.com",
+            "example.net"
+        ],
+        "ip": "93.184.215.14",
+        "ips": [
+            "93.184.215.14",
+            "2606:2800::14"
+        ],
+        "asn": 15133,
+        "prefix": "93.184.215.0/24"
+    },
+    {
+        "domain": "google.com",
+        "domains": [
+            "google.com"
+        ],
+        "ip": "142.250.74.46",
+        "asn": 15169,
+        "prefix": "142.250.0.0/15"
+    },
+    {
+        "domain": "example.com",
+        "domains": [
+            "example.com",
+            "example.net"
+        ],
+        "ip": "93.184.215.14",
+        "ips": [
+            "93.184.215.14",
+            "2606:2800::14"
+        ],
+        "asn": 15133,
+        "prefix": "2606:2800::/32"
+    }
+]`
+
+// С -include-failed неудачные домены добавляются в конец с причиной
+const pipelineCSV = `domain,asn,prefix,ip,error
+example.com;example.net,15133,93.184.215.0/24,93.184.215.14;2606:2800::14,
+google.com,15169,142.250.0.0/15,142.250.74.46,
+example.com;example.net,15133,2606:2800::/32,93.184.215.14;2606:2800::14,
+gone.example,,,,error getting IPs for domain gone.example: failed to resolve gone.example: lookup gone.example: no such host
+reddit.com,,,,failed to get AS numbers for domain reddit.com: AS number not found in whois response
+github.io,,,,failed to get IP prefixes for domain github.io: AS54113: received non-200 response: 404 Not Found
+github.com,,,,no allowed AS numbers found for domain: github.com
+`
+
+func TestRunPipeline(t *testing.T) {
+	dir := t.TempDir()
+	cfg := pipelineConfig(t)
+	cfg.Output = filepath.Join(dir, "prefix.json")
+	cfg.Flat = true
+
+	result, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Failed() != 4 {
+		t.Errorf("Failed() = %d, want 4", result.Failed())
+	}
+	// Ошибки доменов оборачивают виды ошибок этапа, на котором домен не удался
+	wantErrs := map[int]error{3: ErrResolve, 4: ErrASNNotFound, 5: ErrAPIStatus, 6: ErrNoAllowedASNs}
+	for i, want := range wantErrs {
+		if !errors.Is(result.Errors[i], want) {
+			t.Errorf("%s: err = %v, want %v", result.Domains[i], result.Errors[i], want)
+		}
+	}
+	var statusErr *APIStatusError
+	if !errors.As(result.Errors[5], &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Errorf("%s: err = %v, want an APIStatusError with status 404", result.Domains[5], result.Errors[5])
+	}
+	// Без фильтров домен с ошибкой whois — не ErrNoAllowedASNs
+	if errors.Is(result.Errors[4], ErrNoAllowedASNs) {
+		t.Errorf("%s: whois failure reported as %v", result.Domains[4], result.Errors[4])
+	}
+	got, err := os.ReadFile(cfg.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != pipelineJSON {
+		t.Errorf("JSON output:\n%s\nwant:\n%s", got, pipelineJSON)
+	}
+
+	cfg.Output = filepath.Join(dir, "prefix.csv")
+	cfg.Format = FormatCSV
+	cfg.IncludeFailed = true
+	if _, err := Run(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	got, err = os.ReadFile(cfg.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != pipelineCSV {
+		t.Errorf("CSV output:\n%s\nwant:\n%s", got, pipelineCSV)
+	}
+}
+
+func TestRunASNsFiltered(t *testing.T) {
+	var mu sync.Mutex
+	var requested []string
+	_, client := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, path.Base(r.URL.Path))
+		mu.Unlock()
+		fmt.Fprint(w, `{"prefixes":[{"Prefix":"93.184.215.0/24","Count":1}]}`)
+	}))
+	cfg := fixtureConfig("")
+	cfg.HTTPClient = client
+	cfg.Concurrency = 1
+	// AS64512 частная, AS36459 в списке запрета
+	cfg.ASNs = []int{15133, 64512, 36459}
+	cfg.DenyASNs = []int{36459}
+
+	result, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"15133"}; !reflect.DeepEqual(requested, want) {
+		t.Errorf("requested prefixes of %v, want only %v", requested, want)
+	}
+	if len(result.Records) != 1 || result.Records[0].ASN != 15133 {
+		t.Errorf("Records = %+v, want only AS15133", result.Records)
+	}
+	for i, want := range []error{nil, ErrNoAllowedASNs, ErrNoAllowedASNs} {
+		if err := result.Errors[i]; want == nil && err != nil || want != nil && !errors.Is(err, want) {
+			t.Errorf("%s: err = %v, want %v", result.Domains[i], err, want)
+		}
+	}
+
+	// С -allow-private-asn частная AS запрашивается, запрет по-прежнему действует
+	requested = nil
+	cfg.AllowPrivateASN = true
+	if _, err := Run(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"15133", "64512"}; !reflect.DeepEqual(requested, want) {
+		t.Errorf("with AllowPrivateASN requested %v, want %v", requested, want)
+	}
+}
@@ -0,0 +1,122 @@
+package asnprefix
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// RunStats — итоговые числа запуска для сводки и -stats-file
+type RunStats struct {
+	Domains         int     `json:"domains"`
+	Succeeded       int     `json:"succeeded"`
+	Failed          int     `json:"failed"`
+	ASNs            int     `json:"asns"` // Различные AS, найденные для всех доменов
+	Prefixes        int     `json:"prefixes"`
+	IPv4Prefixes    int     `json:"ipv4_prefixes"`
+	IPv6Prefixes    int     `json:"ipv6_prefixes"`
+	DurationSeconds float64 `json:"duration_seconds"`
+
+	Stages map[string]StageStats `json:"stages,omitempty"` // Время запросов по этапам: dns, whois, prefixes, verify
+}
+
+// StageStats — число запросов этапа и их суммарное время. Запросы идут параллельно,
+// поэтому сумма может превышать длительность запуска.
+type StageStats struct {
+	Requests       int64   `json:"requests"`
+	TotalSeconds   float64 `json:"total_seconds"`
+	AverageSeconds float64 `json:"average_seconds"`
+}
+
+// Порядок этапов в сводке
+var stageOrder = []string{sourceDNS, sourceWhois, sourcePrefixes, sourceVerify}
+
+// Stats подсчитывает итоги запуска; elapsed — полное время работы
+func (r *Result) Stats(elapsed time.Duration) RunStats {
+	stats := RunStats{
+		Domains:         len(r.Domains),
+		Failed:          r.Failed(),
+		DurationSeconds: elapsed.Seconds(),
+	}
+	stats.Succeeded = stats.Domains - stats.Failed
+
+	for source, stat := range r.Latency {
+		if stat.Count == 0 {
+			continue
+		}
+		if stats.Stages == nil {
+			stats.Stages = make(map[string]StageStats)
+		}
+		stats.Stages[source] = StageStats{
+			Requests:       stat.Count,
+			TotalSeconds:   stat.Total.Seconds(),
+			AverageSeconds: (stat.Total / time.Duration(stat.Count)).Seconds(),
+		}
+	}
+
+	asns := make(map[int]bool)
+	for _, report := range r.Reports {
+		for _, asNumber := range report.ASNs {
+			asns[asNumber] = true
+		}
+	}
+	stats.ASNs = len(asns)
+
+	// Записи об ошибках без префикса не учитываются
+	for _, record := range r.Records {
+		p, err := record.CIDR()
+		if err != nil {
+			continue
+		}
+		stats.Prefixes++
+		if p.Addr().Is4() {
+			stats.IPv4Prefixes++
+		} else {
+			stats.IPv6Prefixes++
+		}
+	}
+	return stats
+}
+
+// WriteStatsSummary выводит итоги запуска одной строкой и, если были запросы,
+// строкой времени по этапам
+func WriteStatsSummary(w io.Writer, stats RunStats) error {
+	_, err := fmt.Fprintf(w, "Summary: %d domains (%d succeeded, %d failed), %d ASNs, %d prefixes (%d IPv4, %d IPv6) in %s\n",
+		stats.Domains, stats.Succeeded, stats.Failed, stats.ASNs, stats.Prefixes, stats.IPv4Prefixes, stats.IPv6Prefixes,
+		seconds(stats.DurationSeconds).Round(time.Millisecond))
+	if err != nil || len(stats.Stages) == 0 {
+		return err
+	}
+
+	var parts []string
+	for _, source := range stageOrder {
+		stage, ok := stats.Stages[source]
+		if !ok {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s %s in %d requests (avg %s)", source,
+			seconds(stage.TotalSeconds).Round(time.Millisecond), stage.Requests, seconds(stage.AverageSeconds).Round(time.Millisecond)))
+	}
+	_, err = fmt.Fprintf(w, "Stage timings: %s\n", strings.Join(parts, ", "))
+	return err
+}
+
+// Длительность из числа секунд
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// SaveStats сохраняет итоги запуска в JSON-файл
+func SaveStats(filename string, stats RunStats) error {
+	jsonData, err := json.MarshalIndent(stats, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal stats: %w", err)
+	}
+
+	if err := writeFileAtomic(filename, jsonData, 0644); err != nil {
+		return fmt.Errorf("failed to write stats file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package asnprefix
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Потоковая запись NDJSON: записи домена выводятся сразу после его обработки,
+// в порядке завершения. При дедупликации выводится только первое появление
+// префикса, поэтому поле domains в потоке не заполняется.
+type ndjsonStream struct {
+	mu    sync.Mutex
+	w     *bufio.Writer
+	enc   *json.Encoder
+	file  *os.File // Временный файл рядом с целевым; nil при выводе в stdout
+	path  string
+	dedup bool
+	seen  map[string]bool
+	err   error
+}
+
+// Открытие потока для файла filename ("-" — стандартный вывод)
+func newNDJSONStream(filename string, dedup bool, perm os.FileMode) (*ndjsonStream, error) {
+	s := &ndjsonStream{path: filename, dedup: dedup, seen: make(map[string]bool)}
+
+	var out io.Writer = os.Stdout
+	if filename != "-" {
+		file, err := createTempFile(filename, perm)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create output file: %w", err)
+		}
+		s.file, out = file, file
+	}
+	s.w = bufio.NewWriter(out)
+	s.enc = json.NewEncoder(s.w)
+	return s, nil
+}
+
+// Вывод записей одного домена; первая ошибка записи сохраняется до close
+func (s *ndjsonStream) write(records []PrefixRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return
+	}
+
+	for _, r := range records {
+		if s.dedup && r.Prefix != "" {
+			if s.seen[r.Prefix] {
+				continue
+			}
+			s.seen[r.Prefix] = true
+		}
+		if s.err = s.enc.Encode(r); s.err != nil {
+			return
+		}
+	}
+	// Сбрасываем буфер, чтобы потребитель получил записи домена сразу
+	s.err = s.w.Flush()
+}
+
+// Отказ от потока: временный файл удаляется, целевой файл остаётся прежним
+func (s *ndjsonStream) discard() {
+	if s == nil || s.file == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
+
+// Завершение потока: временный файл переименовывается в целевой
+func (s *ndjsonStream) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err == nil {
+		s.err = s.w.Flush()
+	}
+	if s.file == nil {
+		if s.err != nil {
+			return fmt.Errorf("failed to write to stdout: %w", s.err)
+		}
+		return nil
+	}
+
+	defer os.Remove(s.file.Name())
+	if err := s.file.Close(); err != nil && s.err == nil {
+		s.err = err
+	}
+	if s.err == nil {
+		s.err = os.Rename(s.file.Name(), s.path)
+	}
+	if s.err != nil {
+		return fmt.Errorf("failed to write output file: %w", s.err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package asnprefix
+
+import (
+	"sort"
+	"strings"
+)
+
+// Метки доменов во входном файле. Грамматика строки:
+//
+//	строка    = домен [ пробелы "#" комментарий ]
+//	комментарий = { слово | метка }
+//	метка     = ключ ":" значение
+//	ключ      = 1*( a-z | 0-9 | "_" | "-" )   (регистр не учитывается)
+//	значение  = 1*( любой символ, кроме пробельных )
+//
+// Комментарий начинается с "#", перед которым стоит пробел или табуляция, поэтому
+// "#" внутри URL (фрагмент) комментарием не считается. Слова без ":" — обычный
+// текст комментария и меткой не являются. Например, строка
+//
+//	example.com  # category:ads owner:team-x реклама
+//
+// даёт домену метки "category:ads" и "owner:team-x"; они переносятся на все
+// префиксы домена в поле tags. Метки уникальны и отсортированы.
+
+// Отделение комментария от записи домена; возвращает запись и метки из комментария
+func splitAnnotation(line string) (string, []string) {
+	pos := -1
+	for i := 1; i < len(line); i++ {
+		if line[i] == '#' && (line[i-1] == ' ' || line[i-1] == '\t') {
+			pos = i
+			break
+		}
+	}
+	if pos < 0 {
+		return line, nil
+	}
+
+	var tags []string
+	for _, word := range strings.Fields(line[pos+1:]) {
+		if tag, ok := parseTag(word); ok {
+			tags = mergeTags(tags, []string{tag})
+		}
+	}
+	return strings.TrimSpace(line[:pos]), tags
+}
+
+// Разбор слова комментария как метки "ключ:значение"; ключ приводится к нижнему регистру
+func parseTag(word string) (string, bool) {
+	key, value, ok := strings.Cut(word, ":")
+	if !ok || key == "" || value == "" {
+		return "", false
+	}
+	key = strings.ToLower(key)
+	for _, c := range key {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return "", false
+		}
+	}
+	return key + ":" + value, true
+}
+
+// Объединение списков меток без повторов, в порядке сортировки
+func mergeTags(a, b []string) []string {
+	if len(b) == 0 {
+		return a
+	}
+	merged := append([]string(nil), a...)
+	for _, tag := range b {
+		merged = appendUnique(merged, tag)
+	}
+	sort.Strings(merged)
+	return merged
+}
+
+// Копия записей домена с его метками; без меток записи возвращаются как есть
+func tagRecords(records []PrefixRecord, tags []string) []PrefixRecord {
+	if len(tags) == 0 {
+		return records
+	}
+	tagged := make([]PrefixRecord, len(records))
+	for i, r := range records {
+		r.Tags = mergeTags(r.Tags, tags)
+		tagged[i] = r
+	}
+	return tagged
+}
@@ -0,0 +1,135 @@
+package asnprefix
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+)
+
+// Вывод по шаблону -template (text/template, формат template). Шаблон применяется к каждой
+// записи с префиксом; данные записи доступны по именам полей JSON:
+//
+//	.domain .domains .idn .ip .ips .asn .as_name .record_type .tags .prefix
+//
+// и .family — 4 или 6. Шаблоны -template-header и -template-footer выполняются
+// один раз до и после записей с данными .count (число записей) и .records (их список).
+// Функция join объединяет список через разделитель: {{join .ips ","}}. Если вывод
+// шаблона не заканчивается переводом строки, он добавляется. Обращение к
+// несуществующему полю — ошибка, она обнаруживается при проверке настроек.
+type outputTemplate struct {
+	record *template.Template
+	header *template.Template
+	footer *template.Template
+}
+
+// Функции, доступные в шаблонах
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+// Разбор шаблонов из cfg с пробным выполнением на примере записи, чтобы
+// ошибки в именах полей стали видны до начала обработки
+func newOutputTemplate(cfg Config) (*outputTemplate, error) {
+	if cfg.Template == "" {
+		return nil, fmt.Errorf("-format %s requires -template", FormatTemplate)
+	}
+
+	var t outputTemplate
+	var err error
+	if t.record, err = parseOutputTemplate("template", cfg.Template); err != nil {
+		return nil, err
+	}
+	if t.header, err = parseOutputTemplate("template-header", cfg.TemplateHeader); err != nil {
+		return nil, err
+	}
+	if t.footer, err = parseOutputTemplate("template-footer", cfg.TemplateFooter); err != nil {
+		return nil, err
+	}
+
+	sample := []PrefixRecord{{Domain: "example.com", IP: "192.0.2.1", ASN: 64500, Prefix: "192.0.2.0/24"}}
+	if _, err := t.encode(sample); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Разбор одного шаблона; пустой текст — шаблона нет
+func parseOutputTemplate(name, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	t, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -%s: %w", name, err)
+	}
+	return t, nil
+}
+
+// Данные записи для шаблона; списки domains и ips не пустые, даже если
+// у записи один домен и один адрес
+func templateRecord(r PrefixRecord) map[string]interface{} {
+	domains := r.Domains
+	if len(domains) == 0 {
+		domains = []string{r.Domain}
+	}
+	family := 4
+	if p, err := r.CIDR(); err == nil && p.Addr().Is6() {
+		family = 6
+	}
+	return map[string]interface{}{
+		"domain":      r.Domain,
+		"domains":     domains,
+		"idn":         r.IDN,
+		"ip":          r.IP,
+		"ips":         r.addresses(),
+		"asn":         r.ASN,
+		"as_name":     r.ASName,
+		"record_type": r.RecordType,
+		"tags":        r.Tags,
+		"prefix":      r.Prefix,
+		"family":      family,
+	}
+}
+
+// Вывод записей по шаблонам; записи об ошибках без префикса пропускаются
+func (t *outputTemplate) encode(data []PrefixRecord) ([]byte, error) {
+	var records []map[string]interface{}
+	for _, r := range data {
+		if r.Prefix != "" {
+			records = append(records, templateRecord(r))
+		}
+	}
+
+	var buf bytes.Buffer
+	summary := map[string]interface{}{"count": len(records), "records": records}
+	if err := executeTemplate(&buf, t.header, summary); err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if err := executeTemplate(&buf, t.record, record); err != nil {
+			return nil, err
+		}
+	}
+	if err := executeTemplate(&buf, t.footer, summary); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Выполнение шаблона с переводом строки в конце вывода; nil-шаблон ничего не выводит
+func executeTemplate(w io.Writer, t *template.Template, data interface{}) error {
+	if t == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return fmt.Errorf("failed to execute -%s: %w", t.Name(), err)
+	}
+	if buf.Len() > 0 && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+		buf.WriteByte('\n')
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
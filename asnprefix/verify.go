@@ -0,0 +1,120 @@
+package asnprefix
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ответ RIPEstat routing-status: AS, из которых префикс виден в BGP сейчас
+type routingStatusResponse struct {
+	Status string `json:"status"`
+	Data   *struct {
+		Origins *[]struct {
+			Origin int `json:"origin"`
+		} `json:"origins"`
+	} `json:"data"`
+}
+
+// AS, анонсирующие префикс по данным RIPEstat; пустой список — префикс не анонсируется
+func getRoutingOrigins(ctx context.Context, api apiClient, prefix string) ([]int, error) {
+	url := "https://stat.ripe.net/data/routing-status/data.json?resource=" + url.QueryEscape(prefix)
+
+	var apiResponse routingStatusResponse
+	if err := api.fetchJSON(ctx, url, &apiResponse); err != nil {
+		return nil, err
+	}
+	return apiResponse.origins(prefix)
+}
+
+// Проверка ответа routing-status и список AS из него
+func (r routingStatusResponse) origins(prefix string) ([]int, error) {
+	if r.Status != "ok" {
+		return nil, fmt.Errorf("RIPEstat returned status %q", r.Status)
+	}
+	if r.Data == nil || r.Data.Origins == nil {
+		return nil, fmt.Errorf("RIPEstat %s: %w: missing \"data.origins\" field", prefix, ErrUnexpectedResponse)
+	}
+
+	origins := make([]int, 0, len(*r.Data.Origins))
+	for _, o := range *r.Data.Origins {
+		origins = append(origins, o.Origin)
+	}
+	return origins, nil
+}
+
+// Проверка префикса записи: пустая строка, если он анонсируется её AS,
+// иначе описание расхождения
+func verifyPrefix(ctx context.Context, api apiClient, r PrefixRecord, cfg Config) string {
+	if err := cfg.limiter.wait(ctx); err != nil {
+		return fmt.Sprintf("check failed: %v", err)
+	}
+	defer cfg.latency.observe(sourceVerify, time.Now())
+
+	var origins []int
+	var err error
+	if cfg.Fixtures != "" {
+		origins, err = fixtureDir(cfg.Fixtures).routingOrigins(ctx, r.Prefix)
+	} else {
+		origins, err = getRoutingOrigins(ctx, api, r.Prefix)
+	}
+	switch {
+	case err != nil:
+		return fmt.Sprintf("check failed: %v", err)
+	case len(origins) == 0:
+		return "not announced"
+	}
+	for _, origin := range origins {
+		if origin == r.ASN {
+			return ""
+		}
+	}
+
+	names := make([]string, len(origins))
+	for i, origin := range origins {
+		names[i] = "AS" + strconv.Itoa(origin)
+	}
+	return "announced by " + strings.Join(names, ",") + ", not AS" + strconv.Itoa(r.ASN)
+}
+
+// Проверка всех префиксов результата по второму источнику (RIPEstat routing-status).
+// Расхождения записываются в отчёты доменов, давших префикс.
+func verifyRecords(ctx context.Context, records []PrefixRecord, domains []string, reports []DomainReport, cfg Config) {
+	api := newAPIClient(cfg.HTTPClient, cfg)
+	issues := make([]string, len(records))
+	runWorkers(ctx, len(records), cfg.Concurrency, func(i int) {
+		if records[i].Prefix != "" {
+			issues[i] = verifyPrefix(ctx, api, records[i], cfg)
+		}
+	})
+
+	index := make(map[string]int, len(domains))
+	for i, domain := range domains {
+		index[domain] = i
+	}
+
+	failed := 0
+	for i, issue := range issues {
+		if issue == "" {
+			continue
+		}
+		failed++
+		r := records[i]
+		slog.Warn("Prefix failed verification", "prefix", r.Prefix, "asn", r.ASN, "domain", r.Domain, "issue", issue)
+
+		owners := r.Domains
+		if len(owners) == 0 {
+			owners = []string{r.Domain}
+		}
+		for _, domain := range owners {
+			if pos, ok := index[domain]; ok {
+				reports[pos].Unverified = append(reports[pos].Unverified, r.Prefix+": "+issue)
+			}
+		}
+	}
+	slog.Info("Verified prefixes against RIPEstat", "checked", len(records), "mismatches", failed)
+}
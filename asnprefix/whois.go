@@ -0,0 +1,299 @@
+package asnprefix
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Регулярные выражения для поиска AS номера: строки OriginAS (ARIN),
+// origin (объекты route в RIPE/APNIC) и aut-num, а также сами номера в значении
+var (
+	whoisASLineRe = regexp.MustCompile(`(?im)^\s*(?:OriginAS|origin|aut-num):[ \t]*(.*)$`)
+	asNumberRe    = regexp.MustCompile(`(?i)\bAS(\d+)\b`)
+)
+
+// Разбор -asn-regex: выражение должно компилироваться и содержать ровно одну
+// группу захвата с номером AS; пустая строка — встроенные выражения (nil)
+func compileASNRegex(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ASN regex %q: %w", expr, err)
+	}
+	if re.NumSubexp() != 1 {
+		return nil, fmt.Errorf("invalid ASN regex %q: must have exactly one capture group, has %d", expr, re.NumSubexp())
+	}
+	return re, nil
+}
+
+// Максимальное число переходов по ссылкам на другие whois-серверы
+const maxWhoisReferrals = 4
+
+// Получение номеров AS по IP-адресу через whois начиная с server (пусто — сервер,
+// выбранный самой командой); если ответ ссылается на whois другого регистратора,
+// запрос повторяется там (whois -h). Непустой re заменяет встроенные выражения разбора ответа.
+func getASNumberByWhois(ctx context.Context, ip, server string, re *regexp.Regexp) ([]int, error) {
+	return followWhoisReferrals(ctx, ip, server, re, runWhoisCommand)
+}
+
+// Выполнение команды whois; пустой server — сервер, выбранный самой командой
+func runWhoisCommand(ctx context.Context, server, ip string) (string, error) {
+	args := []string{ip}
+	if server != "" {
+		host, port, err := net.SplitHostPort(server)
+		if err != nil {
+			args = []string{"-h", server, ip}
+		} else {
+			args = []string{"-h", host, "-p", port, ip}
+		}
+	}
+
+	cmd := execCommand(ctx, "whois", args...)
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", commandError("whois", err, stderr.String())
+	}
+	return out.String(), nil
+}
+
+// Запрос номера AS с переходом по ссылкам на whois-сервер регистратора, которому
+// выделен адрес. Число переходов ограничено, повторные серверы не запрашиваются.
+func followWhoisReferrals(ctx context.Context, ip, server string, re *regexp.Regexp, query func(ctx context.Context, server, ip string) (string, error)) ([]int, error) {
+	visited := map[string]bool{server: true}
+	for depth := 0; ; depth++ {
+		response, err := query(ctx, server, ip)
+		if err != nil {
+			return nil, err
+		}
+
+		if asNumbers := parseASNumbers(response, re); len(asNumbers) > 0 {
+			slog.Debug("Whois answered", "ip", ip, "server", server)
+			return asNumbers, nil
+		}
+
+		next := whoisReferral(response)
+		if next == "" || visited[strings.ToLower(next)] {
+			break
+		}
+		if depth >= maxWhoisReferrals {
+			return nil, fmt.Errorf("too many whois referrals for %s", ip)
+		}
+		slog.Debug("Following whois referral", "ip", ip, "server", next)
+		visited[strings.ToLower(next)] = true
+		server = next
+	}
+	return nil, ErrASNNotFound
+}
+
+// Запрос к whois-серверу по протоколу WHOIS (TCP, порт 43) с учётом дедлайна контекста
+func queryWhoisServer(ctx context.Context, server, query string) (string, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "43")
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to whois server %s: %w", server, err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// Закрываем соединение при отмене контекста, чтобы прервать чтение
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if _, err := io.WriteString(conn, query+"\r\n"); err != nil {
+		return "", fmt.Errorf("failed to send whois query to %s: %w", server, err)
+	}
+
+	data, err := ioutil.ReadAll(conn)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("whois query to %s aborted: %w", server, ctx.Err())
+		}
+		return "", fmt.Errorf("failed to read whois response from %s: %w", server, err)
+	}
+	return string(data), nil
+}
+
+// Ссылки на другой whois-сервер: "refer:" и "whois:" в ответе IANA,
+// "ReferralServer: whois://host[:port]" в ответе ARIN
+var whoisReferRe = regexp.MustCompile(`(?im)^\s*(?:refer|whois|ReferralServer):\s*(\S+)`)
+
+// Whois-сервер, на который ссылается ответ; пусто, если ссылки нет.
+// Ссылки rwhois:// и другие протоколы не поддерживаются.
+func whoisReferral(response string) string {
+	for _, match := range whoisReferRe.FindAllStringSubmatch(response, -1) {
+		server := match[1]
+		if i := strings.Index(server, "://"); i >= 0 {
+			if !strings.EqualFold(server[:i], "whois") {
+				continue
+			}
+			server = server[i+3:]
+		}
+		if server = strings.TrimSuffix(server, "/"); server != "" {
+			return server
+		}
+	}
+	return ""
+}
+
+// Получение номеров AS по IP-адресу встроенным whois-клиентом: сначала server
+// (обычно IANA), затем whois-серверы регистраторов по ссылкам из ответов
+func getASNumberByNativeWhois(ctx context.Context, ip, server string, re *regexp.Regexp) ([]int, error) {
+	return followWhoisReferrals(ctx, ip, server, re, queryWhoisServer)
+}
+
+// Получение номеров AS для множества IP-адресов одним запросом к bulk-интерфейсу
+// Team Cymru (протокол begin/end). Адреса, для которых AS не найдена, в ответ не попадают.
+func getASNumbersBulk(ctx context.Context, ips []string) (map[string][]int, map[int]string, error) {
+	var query strings.Builder
+	query.WriteString("begin\r\nnoheader")
+	for _, ip := range ips {
+		query.WriteString("\r\n" + ip)
+	}
+	query.WriteString("\r\nend")
+
+	response, err := queryWhoisServer(ctx, cymruServer, query.String())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Строки ответа имеют вид "15169   | 8.8.8.8          | GOOGLE, US";
+	// название AS сразу сохраняется, чтобы не запрашивать его повторно
+	asNumbers := make(map[string][]int)
+	names := make(map[int]string)
+	for _, row := range parseCymruTable(response) {
+		asNumbers[row.IP] = row.ASNs
+		if row.ASName != "" && len(row.ASNs) == 1 {
+			names[row.ASNs[0]] = row.ASName
+		}
+	}
+
+	if len(asNumbers) == 0 && len(ips) > 0 {
+		return nil, nil, fmt.Errorf("no AS numbers in bulk whois response")
+	}
+	return asNumbers, names, nil
+}
+
+// LookupASN возвращает номера AS для IP-адреса, используя выбранный в cfg whois-клиент
+func LookupASN(ctx context.Context, ip string, cfg Config) ([]int, error) {
+	if err := cfg.limiter.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	defer cfg.latency.observe(sourceWhois, time.Now())
+
+	re, err := compileASNRegex(cfg.ASNRegex)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Fixtures != "" {
+		return fixtureDir(cfg.Fixtures).asNumbers(ctx, ip, re)
+	}
+
+	var lookup func(ctx context.Context, ip, server string, re *regexp.Regexp) ([]int, error)
+	servers := cfg.WhoisServers
+	switch cfg.Whois {
+	case WhoisCommand:
+		lookup = getASNumberByWhois
+		if len(servers) == 0 {
+			servers = []string{""}
+		}
+	case WhoisNative, WhoisBulk:
+		// В bulk-режиме сюда попадают адреса, не найденные общим запросом
+		lookup = getASNumberByNativeWhois
+		if len(servers) == 0 {
+			servers = []string{whoisIANAServer}
+		}
+	default:
+		return nil, fmt.Errorf("unknown whois client: %s", cfg.Whois)
+	}
+
+	// При ошибке или таймауте сервера запрос повторяется на следующем с экспоненциальной задержкой
+	for attempt, server := range servers {
+		if attempt > 0 {
+			delay := backoffDelay(cfg.RetryBaseDelay, attempt-1)
+			slog.Warn("Whois server failed, trying the next one", "ip", ip, "server", servers[attempt-1], "next", server, "delay", delay, "error", err)
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, fmt.Errorf("whois retry aborted: %w", ctx.Err())
+			}
+		}
+
+		attemptCtx, cancel := withTimeout(ctx, cfg.WhoisTimeout)
+		var asNumbers []int
+		asNumbers, err = lookup(attemptCtx, ip, server, re)
+		cancel()
+		if err == nil || errors.Is(err, ErrASNNotFound) || ctx.Err() != nil {
+			return asNumbers, err
+		}
+	}
+	return nil, err
+}
+
+// Разбор ответа whois: все различные номера AS в порядке появления. Формат
+// определяется автоматически: табличный ответ Team Cymru или поля OriginAS,
+// origin и aut-num ответов регистраторов. Непустой re (-asn-regex) заменяет
+// автоопределение: номера берутся из его группы захвата во всём ответе.
+func parseASNumbers(response string, re *regexp.Regexp) []int {
+	var asNumbers []int
+	seen := make(map[int]bool)
+
+	if re != nil {
+		for _, match := range re.FindAllStringSubmatch(response, -1) {
+			asNumber, err := ParseASN(match[1])
+			if err != nil || seen[asNumber] {
+				continue
+			}
+			seen[asNumber] = true
+			asNumbers = append(asNumbers, asNumber)
+		}
+		return asNumbers
+	}
+
+	if rows := parseCymruTable(response); len(rows) > 0 {
+		for _, row := range rows {
+			for _, asNumber := range row.ASNs {
+				if !seen[asNumber] {
+					seen[asNumber] = true
+					asNumbers = append(asNumbers, asNumber)
+				}
+			}
+		}
+		return asNumbers
+	}
+
+	for _, line := range whoisASLineRe.FindAllStringSubmatch(response, -1) {
+		for _, match := range asNumberRe.FindAllStringSubmatch(line[1], -1) {
+			asNumber, err := strconv.Atoi(match[1])
+			if err != nil || seen[asNumber] {
+				continue
+			}
+			seen[asNumber] = true
+			asNumbers = append(asNumbers, asNumber)
+		}
+	}
+	return asNumbers
+}
@@ -0,0 +1,158 @@
+package asnprefix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Ответ ARIN для сети с несколькими origin AS (сокращён)
+const arinMultiOriginResponse = `#
+# ARIN WHOIS data and services are subject to the Terms of Use
+#
+
+NetRange:       198.51.96.0 - 198.51.111.255
+CIDR:           198.51.96.0/20
+NetName:        EXAMPLE-ANYCAST
+OriginAS:       AS64500
+OriginAS:       AS64501, AS64502
+OriginAS:       AS64500
+Organization:   Example Anycast Inc. (EA-12)
+
+OrgName:        Example Anycast Inc.
+Comment:        Abuse reports: see AS64999 policy
+`
+
+// Ответ RIPE с объектами route от двух AS для одного префикса (сокращён)
+const ripeMultiRouteResponse = `% This is the RIPE Database query service.
+
+inetnum:        185.15.56.0 - 185.15.59.255
+netname:        WIKIMEDIA-EU
+country:        NL
+
+route:          185.15.58.0/23
+origin:         AS14907
+mnt-by:         WIKIMEDIA-MNT
+
+route:          185.15.58.0/23
+origin:         AS43821
+mnt-by:         WIKIMEDIA-MNT
+`
+
+func TestParseASNumbersMultipleOrigins(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     []int
+	}{
+		// Повторы убираются, порядок — порядок появления; AS в комментариях не учитываются
+		{"arin", arinMultiOriginResponse, []int{64500, 64501, 64502}},
+		{"ripe", ripeMultiRouteResponse, []int{14907, 43821}},
+		{"aut-num", "aut-num:        AS15133\nas-name:        EDGECAST\n", []int{15133}},
+		{"empty origin", "OriginAS:\nNetName: EXAMPLE\n", nil},
+		{"no origin", "% No entries found for the selected source(s).\n", nil},
+	}
+	for _, tt := range tests {
+		if got := parseASNumbers(tt.response, nil); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parseASNumbers = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Ответы whois-серверов по цепочке IANA -> ARIN -> RIPE для адреса RIPE (сокращены)
+var referralResponses = map[string]string{
+	"whois.iana.org": `% IANA WHOIS server
+
+inetnum:      185.0.0.0 - 185.255.255.255
+organisation: RIPE NCC
+status:       ALLOCATED
+
+refer:        whois.arin.net
+`,
+	"whois.arin.net": `NetRange:       185.0.0.0 - 185.255.255.255
+NetName:        RIPE-185
+Organization:   RIPE Network Coordination Centre (RIPE)
+ReferralServer: whois://whois.ripe.net
+`,
+	"whois.ripe.net": ripeMultiRouteResponse,
+}
+
+// Запрос к заготовленным ответам (имена серверов без учёта регистра) с журналом запрошенных серверов
+func fakeWhoisQuery(responses map[string]string, queried *[]string) func(ctx context.Context, server, ip string) (string, error) {
+	return func(ctx context.Context, server, ip string) (string, error) {
+		*queried = append(*queried, server)
+		response, ok := responses[strings.ToLower(server)]
+		if !ok {
+			return "", fmt.Errorf("unexpected whois server %q", server)
+		}
+		return response, nil
+	}
+}
+
+func TestFollowWhoisReferrals(t *testing.T) {
+	var queried []string
+	asNumbers, err := followWhoisReferrals(context.Background(), "185.15.58.224", "whois.iana.org", nil, fakeWhoisQuery(referralResponses, &queried))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{14907, 43821}; !reflect.DeepEqual(asNumbers, want) {
+		t.Errorf("followWhoisReferrals = %v, want %v", asNumbers, want)
+	}
+	if want := []string{"whois.iana.org", "whois.arin.net", "whois.ripe.net"}; !reflect.DeepEqual(queried, want) {
+		t.Errorf("queried servers %v, want %v", queried, want)
+	}
+}
+
+func TestFollowWhoisReferralsLoop(t *testing.T) {
+	// Серверы ссылаются друг на друга: каждый запрашивается один раз
+	responses := map[string]string{
+		"whois.a.example": "NetName: A\nReferralServer: whois://WHOIS.B.EXAMPLE/\n",
+		"whois.b.example": "netname: B\nwhois: whois.a.example\n",
+	}
+	var queried []string
+	_, err := followWhoisReferrals(context.Background(), "192.0.2.1", "whois.a.example", nil, fakeWhoisQuery(responses, &queried))
+	if !errors.Is(err, ErrASNNotFound) {
+		t.Errorf("followWhoisReferrals on a loop: %v, want ErrASNNotFound", err)
+	}
+	if want := []string{"whois.a.example", "WHOIS.B.EXAMPLE"}; !reflect.DeepEqual(queried, want) {
+		t.Errorf("queried servers %v, want %v", queried, want)
+	}
+}
+
+func TestFollowWhoisReferralsDepth(t *testing.T) {
+	// Цепочка длиннее maxWhoisReferrals обрывается с ошибкой
+	responses := make(map[string]string)
+	for i := 0; i <= maxWhoisReferrals+1; i++ {
+		responses[fmt.Sprintf("whois%d.example", i)] = fmt.Sprintf("refer: whois%d.example\n", i+1)
+	}
+	var queried []string
+	_, err := followWhoisReferrals(context.Background(), "192.0.2.1", "whois0.example", nil, fakeWhoisQuery(responses, &queried))
+	if err == nil || !strings.Contains(err.Error(), "too many whois referrals") {
+		t.Errorf("followWhoisReferrals on a long chain: %v, want a referral limit error", err)
+	}
+	if len(queried) != maxWhoisReferrals+1 {
+		t.Errorf("queried %d servers, want %d", len(queried), maxWhoisReferrals+1)
+	}
+}
+
+func TestWhoisReferral(t *testing.T) {
+	tests := []struct {
+		response string
+		want     string
+	}{
+		{"refer:        whois.ripe.net\n", "whois.ripe.net"},
+		{"ReferralServer: whois://whois.apnic.net\n", "whois.apnic.net"},
+		{"ReferralServer: whois://rwhois.example.net:4321/\n", "rwhois.example.net:4321"},
+		// rwhois не поддерживается, берётся следующая ссылка
+		{"ReferralServer: rwhois://rwhois.example.net:4321\nwhois: whois.lacnic.net\n", "whois.lacnic.net"},
+		{"NetName: EXAMPLE\n", ""},
+	}
+	for _, tt := range tests {
+		if got := whoisReferral(tt.response); got != tt.want {
+			t.Errorf("whoisReferral(%q) = %q, want %q", tt.response, got, tt.want)
+		}
+	}
+}
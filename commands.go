@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+
+	"maskSites/asnprefix"
+)
+
+// Разбор флагов подкоманды-этапа, настройка логирования и проверка настроек.
+// Возвращает false, если продолжать нельзя.
+func parseStageFlags(fs *flag.FlagSet, args []string, logs *logFlags, cfg *asnprefix.Config) bool {
+	fs.Parse(args)
+	setupLogging(logs.verbose, logs.quiet)
+	if err := cfg.Validate(); err != nil {
+		slog.Error("Invalid options", "error", err)
+		return false
+	}
+	return true
+}
+
+// Подкоманда resolve: "домен<TAB>IP" для каждого адреса каждого домена
+func runResolve(args []string) int {
+	cfg := baseConfig()
+	fs := newFlagSet("resolve")
+	addResolveFlags(fs, &cfg)
+	addFixtureFlags(fs, &cfg)
+	logs := addLogFlags(fs)
+	if !parseStageFlags(fs, args, logs, &cfg) {
+		return 1
+	}
+
+	cfg.Domains = fs.Args()
+	domains, err := asnprefix.LoadDomains(context.Background(), cfg)
+	if err != nil {
+		slog.Error("Error loading domains", "error", err)
+		return 1
+	}
+
+	failed := 0
+	for _, domain := range domains {
+		ips, err := asnprefix.ResolveDomain(context.Background(), domain, cfg)
+		if err != nil {
+			slog.Error("Domain failed", "domain", domain, "error", err)
+			failed++
+			continue
+		}
+		for _, ip := range ips {
+			fmt.Printf("%s\t%s\n", domain, ip)
+		}
+	}
+	return exitCode(failed)
+}
+
+// Подкоманда asn: "IP<TAB>ASn" для каждой AS каждого адреса
+func runASN(args []string) int {
+	cfg := baseConfig()
+	fs := newFlagSet("asn")
+	addWhoisFlags(fs, &cfg)
+	addRetryFlags(fs, &cfg)
+	addFixtureFlags(fs, &cfg)
+	logs := addLogFlags(fs)
+	if !parseStageFlags(fs, args, logs, &cfg) {
+		return 1
+	}
+	if fs.NArg() == 0 {
+		slog.Error("No IP addresses given")
+		return 1
+	}
+
+	failed := 0
+	for _, ip := range fs.Args() {
+		asNumbers, err := asnprefix.LookupASN(context.Background(), ip, cfg)
+		if err != nil {
+			slog.Error("Error getting AS number", "ip", ip, "error", err)
+			failed++
+			continue
+		}
+		for _, asNumber := range asNumbers {
+			fmt.Printf("%s\tAS%d\n", ip, asNumber)
+		}
+	}
+	return exitCode(failed)
+}
+
+// Подкоманда prefixes: "ASn<TAB>префикс" для каждого префикса каждой AS
+func runPrefixes(args []string) int {
+	cfg := baseConfig()
+	fs := newFlagSet("prefixes")
+	addPrefixFlags(fs, &cfg)
+	addRetryFlags(fs, &cfg)
+	addFixtureFlags(fs, &cfg)
+	logs := addLogFlags(fs)
+	if !parseStageFlags(fs, args, logs, &cfg) {
+		return 1
+	}
+	if fs.NArg() == 0 {
+		slog.Error("No AS numbers given")
+		return 1
+	}
+
+	failed := 0
+	for _, arg := range fs.Args() {
+		asNumber, err := asnprefix.ParseASN(arg)
+		if err != nil {
+			slog.Error("Invalid AS number", "error", err)
+			failed++
+			continue
+		}
+		prefixes, err := asnprefix.FetchPrefixes(context.Background(), asNumber, cfg)
+		if err != nil {
+			slog.Error("Error getting IP prefixes", "asn", asNumber, "error", err)
+			failed++
+			continue
+		}
+		for _, prefix := range prefixes {
+			fmt.Printf("AS%d\t%s\n", asNumber, prefix.Prefix)
+		}
+	}
+	return exitCode(failed)
+}
+
+// Код завершения подкоманды-этапа: 1, если хотя бы один аргумент не обработан
+func exitCode(failed int) int {
+	if failed > 0 {
+		slog.Error("Some lookups failed", "failed", failed)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Применение файла настроек -config (YAML, в том числе JSON): ключи совпадают
+// с именами флагов, значения из командной строки имеют приоритет над файлом
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	// Флаги, явно заданные в командной строке, не переопределяются
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name == "config" || fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown option %q in config file %s", name, path)
+		}
+		if explicit[name] {
+			continue
+		}
+		if err := fs.Set(name, configValueString(values[name])); err != nil {
+			return fmt.Errorf("invalid value for %q in config file %s: %w", name, path, err)
+		}
+	}
+	return nil
+}
+
+// Значение из файла настроек в виде строки флага; списки объединяются через запятую
+func configValueString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			parts = append(parts, configValueString(item))
+		}
+		return strings.Join(parts, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Позиционный аргумент со списком доменов, если -input не задан: он задаётся
+// как флаг -input и поэтому, как и другие флаги, важнее значения из файла настроек
+func applyInputArg(fs *flag.FlagSet) {
+	if fs.NArg() == 0 || fs.Lookup("input") == nil {
+		return
+	}
+	explicit := false
+	fs.Visit(func(f *flag.Flag) {
+		explicit = explicit || f.Name == "input"
+	})
+	if !explicit {
+		fs.Set("input", fs.Arg(0))
+	}
+}
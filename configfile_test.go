@@ -0,0 +1,172 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"maskSites/asnprefix"
+)
+
+// Набор флагов как у подкоманды run: общие группы флагов и несколько собственных
+func testFlagSet(cfg *asnprefix.Config) *flag.FlagSet {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.String("config", "", "")
+	fs.StringVar(&cfg.Input, "input", "", "")
+	fs.StringVar(&cfg.Output, "output", "", "")
+	fs.BoolVar(&cfg.Dedup, "dedup", true, "")
+	fs.IntVar(&cfg.Concurrency, "concurrency", 4, "")
+	addResolveFlags(fs, cfg)
+	addWhoisFlags(fs, cfg)
+	addPrefixFlags(fs, cfg)
+	return fs
+}
+
+func writeConfig(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const sampleConfig = `# Настройки ночного запуска
+resolver: native
+output: /var/lib/masksites/prefix.json
+dedup: false
+concurrency: 16
+max-retries: 5
+http-timeout: 3s
+whois-servers:
+  - whois.ripe.net
+  - whois.arin.net
+`
+
+func TestApplyConfigFile(t *testing.T) {
+	cfg := baseConfig()
+	fs := testFlagSet(&cfg)
+	if err := fs.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfigFile(fs, writeConfig(t, "config.yaml", sampleConfig)); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Resolver != asnprefix.ResolverNative || cfg.Output != "/var/lib/masksites/prefix.json" || cfg.Dedup ||
+		cfg.Concurrency != 16 || cfg.MaxRetries != 5 || cfg.HTTPTimeout != 3*time.Second {
+		t.Errorf("config file values not applied: %+v", cfg)
+	}
+	if want := []string{"whois.ripe.net", "whois.arin.net"}; !reflect.DeepEqual(cfg.WhoisServers, want) {
+		t.Errorf("WhoisServers = %v, want %v", cfg.WhoisServers, want)
+	}
+	// Ключи, которых нет в файле, сохраняют значения флагов по умолчанию
+	if cfg.PrefixSource != asnprefix.PrefixSourceHE || cfg.Whois != asnprefix.WhoisCommand {
+		t.Errorf("defaults changed: prefix source %q, whois %q", cfg.PrefixSource, cfg.Whois)
+	}
+}
+
+func TestApplyConfigFileFlagPrecedence(t *testing.T) {
+	cfg := baseConfig()
+	fs := testFlagSet(&cfg)
+	args := []string{"-resolver", "doh", "-max-retries=1", "-dedup=true", "-whois-servers", "whois.apnic.net"}
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfigFile(fs, writeConfig(t, "config.yaml", sampleConfig)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Флаги командной строки важнее файла, в том числе явно заданные значения по умолчанию
+	if cfg.Resolver != asnprefix.ResolverDoH {
+		t.Errorf("Resolver = %q, want the -resolver flag value", cfg.Resolver)
+	}
+	if cfg.MaxRetries != 1 {
+		t.Errorf("MaxRetries = %d, want the -max-retries flag value", cfg.MaxRetries)
+	}
+	if !cfg.Dedup {
+		t.Error("Dedup = false, want the -dedup flag value")
+	}
+	if want := []string{"whois.apnic.net"}; !reflect.DeepEqual(cfg.WhoisServers, want) {
+		t.Errorf("WhoisServers = %v, want only the flag value %v", cfg.WhoisServers, want)
+	}
+	// Остальные значения берутся из файла
+	if cfg.Concurrency != 16 || cfg.HTTPTimeout != 3*time.Second {
+		t.Errorf("config file values not applied: concurrency %d, http timeout %s", cfg.Concurrency, cfg.HTTPTimeout)
+	}
+}
+
+func TestApplyConfigFileJSON(t *testing.T) {
+	cfg := baseConfig()
+	fs := testFlagSet(&cfg)
+	fs.Parse(nil)
+	if err := applyConfigFile(fs, writeConfig(t, "config.json", `{"resolver": "doh", "concurrency": 2}`)); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Resolver != asnprefix.ResolverDoH || cfg.Concurrency != 2 {
+		t.Errorf("JSON config not applied: resolver %q, concurrency %d", cfg.Resolver, cfg.Concurrency)
+	}
+}
+
+func TestApplyConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"unknown option", "no-such-flag: 1\n", `unknown option "no-such-flag"`},
+		{"nested config", "config: other.yaml\n", `unknown option "config"`},
+		{"invalid value", "concurrency: many\n", `invalid value for "concurrency"`},
+		{"invalid yaml", "resolver: [dig\n", "failed to parse config file"},
+	}
+	for _, tt := range tests {
+		cfg := baseConfig()
+		fs := testFlagSet(&cfg)
+		fs.Parse(nil)
+		err := applyConfigFile(fs, writeConfig(t, "config.yaml", tt.data))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	cfg := baseConfig()
+	if err := applyConfigFile(testFlagSet(&cfg), filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("applyConfigFile of a missing file succeeded")
+	}
+}
+
+func TestApplyConfigFileInputArg(t *testing.T) {
+	config := writeConfig(t, "config.yaml", "input: /etc/masksites/domains.txt\n")
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		// Файл из командной строки важнее ключа input
+		{"positional argument", []string{"stend.txt"}, "stend.txt"},
+		{"input flag", []string{"-input", "flag.txt"}, "flag.txt"},
+		// При заданном -input позиционный аргумент, как и раньше, не используется
+		{"flag and argument", []string{"-input", "flag.txt", "stend.txt"}, "flag.txt"},
+		{"config only", nil, "/etc/masksites/domains.txt"},
+	}
+	for _, tt := range tests {
+		cfg := baseConfig()
+		fs := testFlagSet(&cfg)
+		if err := fs.Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		applyInputArg(fs)
+		if err := applyConfigFile(fs, config); err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Input != tt.want {
+			t.Errorf("%s: Input = %q, want %q", tt.name, cfg.Input, tt.want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"time"
+
+	"maskSites/asnprefix"
+)
+
+// Настройки по умолчанию для перечислимых полей, которые проверяет Validate;
+// подкоманды, не регистрирующие соответствующие флаги, получают эти значения
+func baseConfig() asnprefix.Config {
+	return asnprefix.Config{
+		Format:            asnprefix.FormatJSON,
+		Resolver:          asnprefix.ResolverDig,
+		Whois:             asnprefix.WhoisCommand,
+		PrefixSource:      asnprefix.PrefixSourceHE,
+		Family:            asnprefix.FamilyBoth,
+		MaxPrefixesAction: asnprefix.MaxPrefixesSkip,
+	}
+}
+
+// Флаги резолва доменов
+func addResolveFlags(fs *flag.FlagSet, cfg *asnprefix.Config) {
+	fs.StringVar(&cfg.Resolver, "resolver", asnprefix.ResolverDig, "DNS resolver to use: dig|native|doh")
+	fs.StringVar(&cfg.DoHURL, "doh-url", asnprefix.DefaultDoHURL, "DNS-over-HTTPS server for -resolver doh (JSON API); -proxy and -http-timeout apply")
+	fs.StringVar(&cfg.DNSServer, "dns-server", "", "DNS server to query, host[:port] (default: system resolver)")
+	fs.DurationVar(&cfg.DNSTimeout, "dns-timeout", 0, "timeout for resolving each domain (0 means no limit)")
+	fs.BoolVar(&cfg.ResolveAllIPs, "resolve-all-ips", false, "run whois on every resolved IP instead of the first IPv4 and IPv6 address")
+	fs.BoolVar(&cfg.IncludePrivate, "include-private", false, "look up private and reserved IPs instead of skipping them")
+	fs.BoolVar(&cfg.IncludeMX, "include-mx", false, "also resolve the domain's MX hosts and collect their prefixes, tagged with record_type MX")
+	fs.BoolVar(&cfg.IncludeNS, "include-ns", false, "also resolve the domain's NS hosts and collect their prefixes, tagged with record_type NS")
+}
+
+// Офлайн-режим: ответы dig, whois и API из директории с заготовками
+func addFixtureFlags(fs *flag.FlagSet, cfg *asnprefix.Config) {
+	fs.StringVar(&cfg.Fixtures, "fixtures", "", "offline mode: read canned dig, whois and API responses from this directory (dig/<name>, whois/<ip>, prefixes/AS<n>.json, routing/<prefix>.json) instead of the network")
+}
+
+// Флаги поиска AS через whois
+func addWhoisFlags(fs *flag.FlagSet, cfg *asnprefix.Config) {
+	fs.StringVar(&cfg.Whois, "whois", asnprefix.WhoisCommand, "whois client to use: command|native|bulk")
+	fs.StringVar(&cfg.ASNRegex, "asn-regex", "", "regular expression with one capture group matching the AS number in whois responses (default: built-in OriginAS/origin/aut-num patterns)")
+	fs.DurationVar(&cfg.WhoisTimeout, "whois-timeout", 0, "timeout for each whois server attempt (0 means no limit)")
+	fs.Func("whois-servers", "comma-separated whois servers tried in order when one fails (default: whois.iana.org, or the whois command's own choice)", func(s string) error {
+		for _, server := range strings.Split(s, ",") {
+			if server = strings.TrimSpace(server); server != "" {
+				cfg.WhoisServers = append(cfg.WhoisServers, server)
+			}
+		}
+		return nil
+	})
+}
+
+// Флаги запроса префиксов AS
+func addPrefixFlags(fs *flag.FlagSet, cfg *asnprefix.Config) {
+	fs.StringVar(&cfg.PrefixSource, "prefix-source", asnprefix.PrefixSourceHE, "prefix source to use: he|ripestat")
+	fs.IntVar(&cfg.MaxRetries, "max-retries", 3, "maximum number of retries for the prefix API")
+	fs.StringVar(&cfg.Proxy, "proxy", "", "HTTP proxy URL for the prefix API (default: HTTP_PROXY/HTTPS_PROXY/NO_PROXY from the environment)")
+	fs.StringVar(&cfg.UserAgent, "user-agent", "", "User-Agent header for HTTP requests (default: maskSites/<version>)")
+	fs.DurationVar(&cfg.HTTPTimeout, "http-timeout", 10*time.Second, "timeout for each prefix API request")
+	fs.Int64Var(&cfg.MaxResponseBytes, "max-response-bytes", asnprefix.DefaultMaxResponseBytes, "maximum size of a prefix API response in bytes (0 means unlimited)")
+}
+
+// Базовая задержка повторов, общая для whois и API префиксов
+func addRetryFlags(fs *flag.FlagSet, cfg *asnprefix.Config) {
+	fs.DurationVar(&cfg.RetryBaseDelay, "retry-base-delay", 500*time.Millisecond, "base delay for exponential backoff between prefix API retries and whois server failovers")
+}
+
+// Флаги уровня логирования
+type logFlags struct {
+	verbose bool
+	quiet   bool
+}
+
+func addLogFlags(fs *flag.FlagSet) *logFlags {
+	var lf logFlags
+	fs.BoolVar(&lf.verbose, "v", false, "verbose logging (shorthand for -verbose)")
+	fs.BoolVar(&lf.verbose, "verbose", false, "verbose logging, including debug messages")
+	fs.BoolVar(&lf.quiet, "quiet", false, "log only warnings and errors")
+	return &lf
+}
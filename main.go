@@ -1,194 +1,368 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"log/slog"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
-	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
-)
-
-// Структура для обработки префиксов
-type Prefix struct {
-	Prefix string `json:"Prefix"`
-	Count  int    `json:"Count"`
-	Total  int    `json:"Total"`
-}
-
-// Структура для общего ответа API
-type ApiResponse struct {
-	Prefixes []Prefix `json:"prefixes"`
-}
 
-// Структура для сохранения префиксов в файл
-type PrefixForFile struct {
-	Hostname string `json:"hostname"`
-	IP       string `json:"ip"`
-}
+	"maskSites/asnprefix"
+)
 
-// Функция для чтения доменов из файла
-func readDomainsFromFile(filename string) ([]string, error) {
-	data, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read file: %w", err)
+// Настройка логирования: сообщения пишутся в stderr, чтобы stdout оставался
+// свободным для данных; уровень задаётся флагами -verbose и -quiet
+func setupLogging(verbose, quiet bool) {
+	level := slog.LevelInfo
+	switch {
+	case quiet:
+		level = slog.LevelWarn
+	case verbose:
+		level = slog.LevelDebug
 	}
 
-	// Разбиваем данные файла на строки (домен на каждой строке)
-	domains := strings.Split(strings.TrimSpace(string(data)), "\n")
-	return domains, nil
+	handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})
+	slog.SetDefault(slog.New(handler))
 }
 
-// Выполнение команды dig для получения IP-адресов домена
-func getIPsByDig(domain string) ([]string, error) {
-	cmd := exec.Command("dig", "+short", domain)
-	var out bytes.Buffer
-	cmd.Stdout = &out
-
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("failed to run dig command: %w", err)
+// Вывод плана запуска для -dry-run: домены и выбранные способы поиска
+func printPlan(ctx context.Context, cfg asnprefix.Config) int {
+	var domains []string
+	if cfg.Input != "" || len(cfg.Domains) > 0 {
+		var err error
+		if domains, err = asnprefix.LoadDomains(ctx, cfg); err != nil {
+			slog.Error("Error loading domains", "error", err)
+			return 1
+		}
 	}
 
-	ips := strings.Split(strings.TrimSpace(out.String()), "\n")
-	return ips, nil
+	fmt.Printf("Dry run: %d domains would be processed\n", len(domains))
+	if len(cfg.ASNs) > 0 {
+		fmt.Printf("ASNs: %v\n", cfg.ASNs)
+	}
+	if cfg.Input != "" {
+		fmt.Printf("Input: %s\n", cfg.Input)
+	}
+	if len(cfg.Domains) > 0 {
+		fmt.Printf("Inline domains: %d\n", len(cfg.Domains))
+	}
+	fmt.Printf("Resolver: %s\n", cfg.Resolver)
+	if cfg.DNSServer != "" {
+		fmt.Printf("DNS server: %s\n", cfg.DNSServer)
+	}
+	fmt.Printf("Whois: %s\n", cfg.Whois)
+	fmt.Printf("Prefix source: %s\n", cfg.PrefixSource)
+	fmt.Printf("Output: %s (format: %s)\n", cfg.Output, cfg.Format)
+	fmt.Println("Domains:")
+	for _, domain := range domains {
+		fmt.Printf("  %s\n", domain)
+	}
+	return 0
 }
 
-// Получение номера AS по IP-адресу через whois
-func getASNumberByWhois(ip string) (int, error) {
-	cmd := exec.Command("whois", ip)
-	var out bytes.Buffer
-	cmd.Stdout = &out
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
 
-	if err := cmd.Run(); err != nil {
-		return 0, fmt.Errorf("failed to run whois command: %w", err)
+// Справка по подкомандам, выводится перед списком флагов
+const usageText = `Usage:
+  maskSites [run] [flags] [domains-file]    full pipeline: domains -> IPs -> AS -> prefixes
+  maskSites resolve [flags] domain...       print the IPs of each domain
+  maskSites asn [flags] ip...               print the AS numbers of each IP
+  maskSites prefixes [flags] asn...         print the prefixes announced by each AS
+  maskSites selftest [flags] [domain]       check tools and one lookup chain
+  maskSites -version                        print build information
+
+Flags:
+`
+
+// Новый набор флагов подкоманды со справкой по всем подкомандам
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usageText)
+		fs.PrintDefaults()
 	}
+	return fs
+}
 
-	// Регулярное выражение для поиска AS номера
-	re := regexp.MustCompile(`OriginAS:\s+AS(\d+)`)
-	match := re.FindStringSubmatch(out.String())
-	if len(match) < 2 {
-		return 0, fmt.Errorf("AS number not found in whois response")
+// Выбор подкоманды; без подкоманды выполняется весь конвейер (run).
+// Возвращает код завершения процесса.
+func run(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "run":
+			return runPipeline(args[1:])
+		case "resolve":
+			return runResolve(args[1:])
+		case "asn":
+			return runASN(args[1:])
+		case "prefixes":
+			return runPrefixes(args[1:])
+		case "selftest":
+			return runSelftestCommand(args[1:])
+		}
 	}
-
-	asNumber := match[1]
-	return strconv.Atoi(asNumber)
+	return runPipeline(args)
 }
 
-// Функция для получения IP префиксов по AS номеру
-func getIPPrefixes(asNumber int) ([]Prefix, error) {
-	url := fmt.Sprintf("https://bgp.he.net/super-lg/report/api/v1/prefixes/originated/%d", asNumber)
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+// Подкоманда run: весь конвейер с сохранением результата
+func runPipeline(args []string) int {
+	cfg := baseConfig()
+	var failOnError, dryRun, showVersion bool
+	var configPath, reportPath, diffPath, metricsPath, statsPath, domainList, allowASNs, denyASNs, excludeCIDRs string
+	fs := newFlagSet("run")
+	fs.StringVar(&configPath, "config", "", "YAML config file with option values; command-line flags take precedence")
+	fs.StringVar(&cfg.Input, "input", "", "comma-separated domain files, globs or directories of *.txt files, http(s) URLs, or - for stdin (default: domains.txt next to the executable); a line may end with \"# key:value ...\" tags copied to its prefixes")
+	fs.StringVar(&domainList, "domains", "", "comma-separated domains to process; merged with -input if both are given")
+	fs.Func("asn", "AS numbers to fetch prefixes for directly, comma-separated or repeated (64500 or AS64500); -allow-asn, -deny-asn and -allow-private-asn apply", func(s string) error {
+		asns, err := asnprefix.ParseASNList(s)
+		cfg.ASNs = append(cfg.ASNs, asns...)
+		return err
+	})
+	fs.IntVar(&cfg.Limit, "limit", 0, "process only the first N valid domains (0 means no limit)")
+	fs.StringVar(&cfg.Output, "output", "", "output file path, or - for stdout (default: prefix.json next to the executable)")
+	fs.StringVar(&cfg.OutputDir, "output-dir", "", "also write <domain>.json with each domain's AS numbers and prefixes into this directory")
+	cfg.OutputMode = asnprefix.DefaultOutputMode
+	fs.Func("output-mode", "octal permissions of the output file, subject to the umask (default 0644)", func(s string) error {
+		mode, err := strconv.ParseUint(s, 8, 32)
+		if err != nil || mode > 0777 {
+			return fmt.Errorf("invalid file mode %q: want octal permissions such as 0640", s)
+		}
+		cfg.OutputMode = os.FileMode(mode)
+		return nil
+	})
+	fs.StringVar(&cfg.Format, "format", asnprefix.FormatJSON, "output format: json|ndjson|csv|tsv|plain|ipset|nft|template")
+	fs.StringVar(&cfg.SetName, "set-name", "prefixes", "base set name for ipset and nft output (_v4/_v6 suffixes are added)")
+	fs.StringVar(&cfg.Template, "template", "", "Go text/template applied to every prefix, e.g. 'allow {{.prefix}}; # {{.domain}} AS{{.asn}}'; fields: domain, domains, idn, ip, ips, asn, as_name, record_type, tags, prefix, family (implies -format template)")
+	fs.StringVar(&cfg.TemplateHeader, "template-header", "", "template written once before the -template records; fields: count, records")
+	fs.StringVar(&cfg.TemplateFooter, "template-footer", "", "template written once after the -template records; fields: count, records")
+	fs.BoolVar(&cfg.ASNames, "asn-names", false, "add the AS holder name to each record (json and csv output)")
+	fs.BoolVar(&cfg.IncludeFailed, "include-failed", false, "add an entry with an error field for every failed domain (json and csv output)")
+	fs.BoolVar(&cfg.Flat, "flat", false, "write JSON as a plain array of records, without the meta section")
+	fs.BoolVar(&cfg.JSONCompact, "json-compact", false, "write JSON without indentation (smaller files, faster to parse)")
+	fs.BoolVar(&cfg.Append, "append", false, "merge the new prefixes into the existing output file instead of replacing it (json, ndjson or plain)")
+	fs.BoolVar(&cfg.LegacyFormat, "legacy-format", false, "write JSON in the old {hostname, ip} schema")
+	fs.IntVar(&cfg.Concurrency, "concurrency", runtime.NumCPU(), "number of domains processed in parallel")
+	addResolveFlags(fs, &cfg)
+	addWhoisFlags(fs, &cfg)
+	addPrefixFlags(fs, &cfg)
+	addRetryFlags(fs, &cfg)
+	addFixtureFlags(fs, &cfg)
+	fs.StringVar(&allowASNs, "allow-asn", "", "comma-separated AS numbers to fetch prefixes for; others are skipped")
+	fs.StringVar(&denyASNs, "deny-asn", "", "comma-separated AS numbers whose prefixes are never fetched")
+	fs.BoolVar(&cfg.AllowPrivateASN, "allow-private-asn", false, "fetch prefixes for private and reserved AS numbers (64512-65534, 4200000000-4294967294, ...) instead of skipping them")
+	fs.BoolVar(&cfg.Dedup, "dedup", true, "merge duplicate prefixes across domains")
+	fs.BoolVar(&cfg.Verify, "verify", false, "check that every prefix is currently announced by its AS (RIPEstat routing-status) and flag mismatches in the report")
+	fs.BoolVar(&cfg.Aggregate, "aggregate", false, "merge covered and adjacent prefixes into a minimal CIDR set")
+	fs.IntVar(&cfg.MaxPrefixes, "max-prefixes", 0, "maximum number of prefixes taken from a single AS (0 means unlimited)")
+	fs.StringVar(&cfg.MaxPrefixesAction, "max-prefixes-action", asnprefix.MaxPrefixesSkip, "what to do with an AS over -max-prefixes: skip|truncate")
+	fs.BoolVar(&cfg.Sort, "sort", false, "sort the written prefixes by address and mask length for deterministic output")
+	fs.StringVar(&cfg.Family, "family", asnprefix.FamilyBoth, "address family of the written prefixes: 4|6|both")
+	fs.StringVar(&excludeCIDRs, "exclude-cidr", "", "comma-separated CIDRs; written prefixes contained in any of them are dropped")
+	fs.BoolVar(&cfg.ExcludeOverlap, "exclude-overlap", false, "with -exclude-cidr, also drop prefixes that only overlap an excluded range")
+	fs.Float64Var(&cfg.Rate, "rate", 2, "maximum whois and prefix API requests per second (0 means unlimited)")
+	fs.BoolVar(&failOnError, "fail-on-error", false, "exit with a non-zero status if any domain fails")
+	fs.BoolVar(&cfg.Strict, "strict", false, "abort on the first invalid domain entry and fail domains whose prefix list is truncated")
+	fs.BoolVar(&cfg.Checkpoint, "checkpoint", false, "periodically save completed domains to <output>.checkpoint so an interrupted run can be resumed")
+	fs.StringVar(&cfg.ContinueFrom, "continue-from", "", "resume from this checkpoint file, skipping domains it already completed (keeps checkpointing into it)")
+	fs.DurationVar(&cfg.CheckpointInterval, "checkpoint-interval", asnprefix.DefaultCheckpointInterval, "how often the checkpoint file is rewritten")
+	fs.StringVar(&cfg.ASNMap, "asn-map", "", "JSON or CSV file mapping IPs or CIDRs to AS numbers; whois is skipped for matching IPs")
+	fs.StringVar(&cfg.CacheDir, "cache-dir", "", "directory for the lookup cache (default: next to the executable)")
+	fs.DurationVar(&cfg.CacheTTL, "cache-ttl", 24*time.Hour, "how long cached whois and prefix lookups stay valid")
+	fs.DurationVar(&cfg.DomainCacheTTL, "domain-cache-ttl", 24*time.Hour, "how long a domain's cached IPs, AS numbers and prefixes are reused without any lookups")
+	fs.BoolVar(&cfg.Refresh, "refresh", false, "ignore cached results, look everything up again and update the cache")
+	fs.BoolVar(&cfg.NoCache, "no-cache", false, "disable the lookup cache")
+	fs.DurationVar(&cfg.Timeout, "timeout", 0, "overall deadline for the whole run (0 means no deadline)")
+	fs.IntVar(&cfg.MaxResolveFailures, "max-resolve-failures", 10, "abort the run after this many consecutive resolver failures, such as dig finding no reachable servers (0 disables)")
+	fs.IntVar(&cfg.BreakerThreshold, "breaker-threshold", asnprefix.DefaultBreakerThreshold, "after this many consecutive prefix API failures, fail prefix requests fast for -breaker-cooldown (0 disables)")
+	fs.DurationVar(&cfg.BreakerCooldown, "breaker-cooldown", asnprefix.DefaultBreakerCooldown, "how long the prefix API circuit breaker stays open before a probe request is allowed")
+	fs.DurationVar(&cfg.DomainTimeout, "domain-timeout", 30*time.Second, "abandon a domain that takes longer than this (0 means no limit)")
+	fs.StringVar(&reportPath, "report", "", "write a per-domain JSON report to this file")
+	fs.StringVar(&cfg.DiffAgainst, "diff-against", "", "previous JSON output to compare with; added and removed prefixes are reported")
+	fs.StringVar(&diffPath, "diff-output", "", "write the -diff-against result to this JSON file instead of stderr")
+	fs.StringVar(&metricsPath, "metrics-file", "", "write run metrics to this file in Prometheus textfile format")
+	fs.StringVar(&statsPath, "stats-file", "", "write the run summary (domains, ASNs, prefixes, duration) to this JSON file")
+	fs.BoolVar(&dryRun, "dry-run", false, "print the domains and settings that would be used, without any lookups")
+	fs.BoolVar(&showVersion, "version", false, "print the version, git commit and build date, then exit")
+	logs := addLogFlags(fs)
+	fs.Parse(args)
+
+	if showVersion {
+		fmt.Println(asnprefix.ReadBuildInfo())
+		return 0
 	}
 
-	resp, err := client.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("failed to GET %s: %w", url, err)
+	// Позиционный аргумент применяется до файла настроек, чтобы ключ input его не переопределял
+	applyInputArg(fs)
+	if configPath != "" {
+		if err := applyConfigFile(fs, configPath); err != nil {
+			setupLogging(logs.verbose, logs.quiet)
+			slog.Error("Error loading config", "error", err)
+			return 1
+		}
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("received non-200 response: %s", resp.Status)
-	}
+	setupLogging(logs.verbose, logs.quiet)
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+	// -template без явного -format включает вывод по шаблону
+	formatSet := false
+	fs.Visit(func(f *flag.Flag) {
+		formatSet = formatSet || f.Name == "format"
+	})
+	if cfg.Template != "" && !formatSet {
+		cfg.Format = asnprefix.FormatTemplate
 	}
 
-	var apiResponse ApiResponse
-	if err := json.Unmarshal(body, &apiResponse); err != nil {
-		return nil, fmt.Errorf("failed to parse JSON: %w", err)
+	var err error
+	if cfg.AllowASNs, err = asnprefix.ParseASNList(allowASNs); err != nil {
+		slog.Error("Invalid -allow-asn", "error", err)
+		return 1
 	}
-
-	return apiResponse.Prefixes, nil
-}
-
-// Функция для сохранения префиксов в файл
-func savePrefixesToFile(data []PrefixForFile, filename string) error {
-	// Сериализуем данные в JSON
-	jsonData, err := json.MarshalIndent(data, "", "    ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal JSON: %w", err)
+	if cfg.DenyASNs, err = asnprefix.ParseASNList(denyASNs); err != nil {
+		slog.Error("Invalid -deny-asn", "error", err)
+		return 1
+	}
+	if cfg.ExcludeCIDRs, err = asnprefix.ParseCIDRList(excludeCIDRs); err != nil {
+		slog.Error("Invalid -exclude-cidr", "error", err)
+		return 1
 	}
 
-	// Записываем JSON в файл
-	if err := ioutil.WriteFile(filename, jsonData, 0644); err != nil {
-		return fmt.Errorf("failed to write JSON to file: %w", err)
+	if err := cfg.Validate(); err != nil {
+		slog.Error("Invalid options", "error", err)
+		return 1
 	}
 
-	return nil
-}
-func main() {
 	// Получение пути к исполняемому файлу
 	exePath, err := os.Executable()
 	if err != nil {
-		fmt.Println("Error getting executable path:", err)
-		return
+		slog.Error("Error getting executable path", "error", err)
+		return 1
 	}
 
 	exeDir := filepath.Dir(exePath)
-	domainsFilePath := filepath.Join(exeDir, "domains.txt")
 
-	// Чтение списка доменов из файла
-	domains, err := readDomainsFromFile(domainsFilePath)
-	if err != nil {
-		fmt.Println("Error reading domains:", err)
-		return
+	// Путь к списку доменов: флаг -input, позиционный аргумент или domains.txt рядом с бинарным файлом;
+	// при заданном -domains файл по умолчанию не читается
+	for _, domain := range strings.Split(domainList, ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			cfg.Domains = append(cfg.Domains, domain)
+		}
+	}
+	if cfg.Input == "" && len(cfg.Domains) == 0 && len(cfg.ASNs) == 0 {
+		cfg.Input = filepath.Join(exeDir, "domains.txt")
 	}
 
-	// Создаем массив для хранения результатов
-	var results []PrefixForFile
+	// По умолчанию результаты и кэш хранятся в той же директории, что и бинарный файл
+	if cfg.Output == "" {
+		cfg.Output = filepath.Join(exeDir, "prefix.json")
+	}
+	if cfg.CacheDir == "" {
+		cfg.CacheDir = exeDir
+	}
 
-	// Проходим по каждому домену
-	for _, domain := range domains {
-		fmt.Printf("Processing domain: %s\n", domain)
+	// SIGINT/SIGTERM отменяют незавершённую работу, а собранные к этому моменту
+	// префиксы всё равно сохраняются; повторный сигнал завершает процесс сразу
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if dryRun {
+		return printPlan(ctx, cfg)
+	}
 
-		ips, err := getIPsByDig(domain)
-		if err != nil {
-			fmt.Printf("Error getting IPs for domain %s: %v\n", domain, err)
-			continue
-		}
+	if !logs.quiet {
+		cfg.OnProgress = newProgressPrinter(os.Stderr).update
+	}
 
-		if len(ips) == 0 {
-			fmt.Printf("No IPs found for domain: %s\n", domain)
-			continue
+	start := time.Now()
+	result, err := asnprefix.Run(ctx, cfg)
+	interrupted := ctx.Err() != nil
+	if interrupted {
+		slog.Warn("Interrupted, keeping partial results")
+	}
+	if errors.Is(err, asnprefix.ErrNoDomains) {
+		// Пустой список — не сбой, если не требуется строгая проверка входных данных
+		if cfg.Strict {
+			slog.Error("No domains to process", "input", cfg.Input)
+			return 1
 		}
-
-		asNumber, err := getASNumberByWhois(ips[0])
-		if err != nil {
-			fmt.Printf("Error getting AS number for domain %s: %v\n", domain, err)
-			continue
+		slog.Warn("No domains to process", "input", cfg.Input)
+		return 0
+	}
+	if err != nil {
+		slog.Error("Run failed", "error", err)
+		if result == nil {
+			return 1
 		}
+	} else if cfg.Output != "-" {
+		slog.Info("Prefixes saved", "path", cfg.Output, "count", len(result.Records))
+	}
 
-		fmt.Printf("AS Number for domain %s (IP: %s): %d\n", domain, ips[0], asNumber)
+	// Итоговая таблица по доменам в stderr, чтобы не смешиваться с данными в stdout
+	if !logs.quiet {
+		asnprefix.WriteReportTable(os.Stderr, result.Reports)
+	}
+	if reportPath != "" {
+		if err := asnprefix.SaveReport(reportPath, result.Reports, cfg.OutputMode); err != nil {
+			slog.Error("Error saving report", "path", reportPath, "error", err)
+		}
+	}
 
-		prefixes, err := getIPPrefixes(asNumber)
-		if err != nil {
-			fmt.Printf("Error getting IP prefixes for AS %d (domain: %s): %v\n", asNumber, domain, err)
-			continue
+	// Сводка в stderr — быстрая проверка итогов запуска
+	stats := result.Stats(time.Since(start))
+	if !logs.quiet {
+		asnprefix.WriteStatsSummary(os.Stderr, stats)
+	}
+	if statsPath != "" {
+		if err := asnprefix.SaveStats(statsPath, stats); err != nil {
+			slog.Error("Error saving stats", "path", statsPath, "error", err)
 		}
+	}
 
-		for _, prefix := range prefixes {
-			results = append(results, PrefixForFile{
-				Hostname: prefix.Prefix,
-				IP:       "", // Оставляем пустым
-			})
+	if metricsPath != "" {
+		if err := asnprefix.SaveMetrics(metricsPath, result); err != nil {
+			slog.Error("Error saving metrics", "path", metricsPath, "error", err)
 		}
 	}
 
-	// Сохраняем результаты в ту же директорию, что и бинарный файл
-	outputFilePath := filepath.Join(exeDir, "prefix.json")
-	if err := savePrefixesToFile(results, outputFilePath); err != nil {
-		fmt.Println("Error saving prefixes:", err)
-		return
+	if result.Diff != nil {
+		if diffPath != "" {
+			if err := asnprefix.SaveDiff(diffPath, result.Diff, cfg.OutputMode); err != nil {
+				slog.Error("Error saving diff", "path", diffPath, "error", err)
+			}
+		} else {
+			asnprefix.WriteDiff(os.Stderr, result.Diff)
+		}
 	}
 
-	fmt.Printf("Prefixes saved to %s\n", outputFilePath)
+	failed := result.Failed()
+	slog.Info("Run finished", "domains", len(result.Domains), "succeeded", len(result.Domains)-failed, "failed", failed, "prefixes", len(result.Records))
+
+	// По умолчанию ошибка — только отсутствие префиксов, с -fail-on-error — любой неудачный домен
+	if err != nil {
+		return 1
+	}
+	if interrupted {
+		return 130
+	}
+	if len(result.Records) == 0 {
+		slog.Error("No prefixes were collected")
+		return 1
+	}
+	if failOnError && failed > 0 {
+		return 1
+	}
+	return 0
 }
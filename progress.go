@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// Индикатор прогресса обработки доменов в stderr. В терминале строка
+// обновляется на месте, иначе периодически выводятся отдельные строки.
+type progressPrinter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	ansi     bool
+	lastStep int
+}
+
+func newProgressPrinter(f *os.File) *progressPrinter {
+	return &progressPrinter{w: f, ansi: ansiEnabled(f)}
+}
+
+// Можно ли писать в файл управляющие последовательности: только в терминал,
+// если их не запрещает окружение
+func ansiEnabled(f *os.File) bool {
+	return ansiAllowedByEnv() && isTerminal(f)
+}
+
+// Разрешены ли управляющие последовательности окружением: NO_COLOR не задан
+// или пуст (https://no-color.org), а терминал не dumb
+func ansiAllowedByEnv() bool {
+	return os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb"
+}
+
+// Проверка, подключён ли файл к терминалу; /dev/null и другие символьные
+// устройства терминалом не считаются
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// Обновление прогресса; подходит для asnprefix.Config.OnProgress
+func (p *progressPrinter) update(done, total int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.ansi {
+		// Возврат каретки и очистка строки перед перерисовкой
+		fmt.Fprintf(p.w, "\r\033[KProcessed %d/%d domains", done, total)
+		if done == total {
+			fmt.Fprintln(p.w)
+		}
+		return
+	}
+
+	// Без терминала выводим строку примерно на каждые 10% и в конце
+	step := done * 10 / total
+	if step > p.lastStep || done == total {
+		p.lastStep = step
+		fmt.Fprintf(p.w, "Processed %d/%d domains\n", done, total)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestIsTerminal(t *testing.T) {
+	// /dev/null — символьное устройство, но не терминал
+	null, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer null.Close()
+	if isTerminal(null) {
+		t.Errorf("%s is reported as a terminal", os.DevNull)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	if isTerminal(w) {
+		t.Error("pipe is reported as a terminal")
+	}
+}
+
+func TestANSIAllowedByEnv(t *testing.T) {
+	tests := []struct {
+		noColor string
+		term    string
+		want    bool
+	}{
+		{"", "xterm-256color", true},
+		// Пустой NO_COLOR цвет не отключает
+		{"", "", true},
+		{"1", "xterm-256color", false},
+		{"false", "xterm", false},
+		{"", "dumb", false},
+	}
+	for _, tt := range tests {
+		t.Setenv("NO_COLOR", tt.noColor)
+		t.Setenv("TERM", tt.term)
+		if got := ansiAllowedByEnv(); got != tt.want {
+			t.Errorf("NO_COLOR=%q TERM=%q: ansiAllowedByEnv() = %t, want %t", tt.noColor, tt.term, got, tt.want)
+		}
+	}
+}
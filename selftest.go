@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"maskSites/asnprefix"
+)
+
+// Домен для проверки цепочки поиска по умолчанию
+const selftestDomain = "example.com"
+
+// Общий дедлайн самопроверки, если не задан -timeout
+const selftestTimeout = time.Minute
+
+// Результат одной проверки самотеста
+type selftestCheck struct {
+	name   string
+	err    error
+	detail string
+}
+
+// Подкоманда selftest с флагами резолва, whois и источника префиксов
+func runSelftestCommand(args []string) int {
+	cfg := baseConfig()
+	fs := newFlagSet("selftest")
+	addResolveFlags(fs, &cfg)
+	addWhoisFlags(fs, &cfg)
+	addPrefixFlags(fs, &cfg)
+	addRetryFlags(fs, &cfg)
+	fs.DurationVar(&cfg.Timeout, "timeout", selftestTimeout, "overall deadline for the self-test")
+	logs := addLogFlags(fs)
+	if !parseStageFlags(fs, args, logs, &cfg) {
+		return 1
+	}
+	return runSelftest(context.Background(), os.Stdout, cfg, fs.Arg(0))
+}
+
+// Самопроверка: наличие внешних программ и одна полная цепочка
+// домен -> IP -> AS -> префиксы; возвращает код завершения процесса
+func runSelftest(ctx context.Context, w io.Writer, cfg asnprefix.Config, domain string) int {
+	if domain == "" {
+		domain = selftestDomain
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = selftestTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var checks []selftestCheck
+	if cfg.Resolver == asnprefix.ResolverDig {
+		path, err := exec.LookPath("dig")
+		checks = append(checks, selftestCheck{name: "dig binary", err: err, detail: path})
+	}
+	if cfg.Whois == asnprefix.WhoisCommand {
+		path, err := exec.LookPath("whois")
+		checks = append(checks, selftestCheck{name: "whois binary", err: err, detail: path})
+	}
+
+	// Этапы цепочки выполняются, пока предыдущий успешен
+	ips, err := asnprefix.ResolveDomain(ctx, domain, cfg)
+	checks = append(checks, selftestCheck{name: "resolve " + domain, err: err, detail: strings.Join(ips, ", ")})
+
+	var asNumbers []int
+	if err == nil {
+		asNumbers, err = asnprefix.LookupASN(ctx, ips[0], cfg)
+		if err == nil && len(asNumbers) == 0 {
+			err = fmt.Errorf("no AS number found for %s", ips[0])
+		}
+		checks = append(checks, selftestCheck{name: "whois " + ips[0], err: err, detail: fmt.Sprint(asNumbers)})
+	}
+
+	if err == nil {
+		prefixes, err := asnprefix.FetchPrefixes(ctx, asNumbers[0], cfg)
+		if err == nil && len(prefixes) == 0 {
+			err = fmt.Errorf("no prefixes returned for AS%d", asNumbers[0])
+		}
+		checks = append(checks, selftestCheck{name: fmt.Sprintf("prefixes AS%d (%s)", asNumbers[0], cfg.PrefixSource), err: err, detail: fmt.Sprintf("%d prefixes", len(prefixes))})
+	}
+
+	failed := 0
+	for _, c := range checks {
+		if c.err != nil {
+			failed++
+			fmt.Fprintf(w, "FAIL  %s: %v\n", c.name, c.err)
+		} else {
+			fmt.Fprintf(w, "PASS  %s: %s\n", c.name, c.detail)
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(w, "%d of %d checks failed\n", failed, len(checks))
+		return 1
+	}
+	fmt.Fprintf(w, "All %d checks passed\n", len(checks))
+	return 0
+}